@@ -0,0 +1,67 @@
+// replay-session feeds a payload capture (server -capture) back into a
+// running server's game event endpoint, in order, at real or scaled speed.
+//
+//	replay-session -speed 4 capture.jsonl
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/capture"
+)
+
+func main() {
+	target := flag.String("target", "http://127.0.0.1:8080/events/game_event", "game event endpoint to post to")
+	speed := flag.Float64("speed", 1, "playback speed multiplier; 0 sends without delays")
+	maxGap := flag.Duration("max-gap", 0, "cap recorded gaps between payloads before scaling (0 = keep)")
+	skip := flag.Int("skip", 0, "skip the first N payloads")
+	verbose := flag.Bool("v", false, "log every payload")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] capture.jsonl\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	entries, err := capture.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("read capture: %v", err)
+	}
+	if *skip > 0 {
+		entries = entries[min(*skip, len(entries)):]
+	}
+	if len(entries) == 0 {
+		log.Fatalf("capture has no payloads")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	recorded := entries[len(entries)-1].At.Sub(entries[0].At)
+	log.Printf("replaying %d payloads (%s recorded) to %s at %gx", len(entries), recorded.Round(time.Second), *target, *speed)
+
+	started := time.Now()
+	err = capture.Play(ctx, entries, *speed, *maxGap, func(i int, e capture.Entry) error {
+		if *verbose {
+			log.Printf("[%d/%d] %s %s", i+1, len(entries), e.At.Format(time.TimeOnly), e.Payload())
+		}
+		return capture.Post(ctx, client, *target, e.Payload())
+	})
+	if err != nil {
+		log.Fatalf("replay stopped: %v", err)
+	}
+
+	log.Printf("done in %s", time.Since(started).Round(time.Millisecond))
+}
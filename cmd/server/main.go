@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,8 +12,11 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/akayumeru/valreplayserver/internal/capture"
+	"github.com/akayumeru/valreplayserver/internal/config"
 	"github.com/akayumeru/valreplayserver/internal/domain"
 	"github.com/akayumeru/valreplayserver/internal/handlers"
 	"github.com/akayumeru/valreplayserver/internal/highlighter"
@@ -22,13 +26,21 @@ import (
 	"github.com/akayumeru/valreplayserver/internal/replays"
 	"github.com/akayumeru/valreplayserver/internal/store"
 	"github.com/akayumeru/valreplayserver/internal/stream"
-	"github.com/andreykaipov/goobs"
+	"github.com/akayumeru/valreplayserver/web"
 	obsEvents "github.com/andreykaipov/goobs/api/events"
 	"github.com/rs/cors"
 )
 
 func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	initial := domain.State{
@@ -42,27 +54,31 @@ func main() {
 		},
 	}
 	st := store.NewStateStore(initial)
+	st.HistoryLimit = cfg.StateHistory
 
-	snapshotter := persist.NewSnapshotter("./state.json", st, 3*time.Second)
+	snapshotter := persist.NewSnapshotter(cfg.StatePath, st, cfg.SnapshotDebounce)
 	if loaded, ok, err := snapshotter.LoadOnStartup(); err != nil {
 		log.Fatalf("snapshot load failed: %v", err)
 	} else if ok {
-		st.Replace(loaded)
+		st.Replace("snapshot_loaded", loaded)
 	}
 
 	go func() {
 		_ = snapshotter.Run(ctx)
 	}()
 
-	var obs *goobs.Client
-	var err error
-
-	if currentState := st.Get(); currentState.ObsConnectionOptions != nil {
-		obs, err = goobs.New(currentState.ObsConnectionOptions.Address, goobs.WithPassword(currentState.ObsConnectionOptions.Password))
-		if err != nil {
-			panic(err)
+	options := st.Get().ObsConnectionOptions
+	switch {
+	case cfg.OBS.Address != "":
+		options = &domain.ObsConnectionOptions{
+			Address:  cfg.OBS.Address,
+			Password: cfg.OBS.Password,
 		}
-	} else {
+	case options != nil:
+	case cfg.NonInteractive:
+		log.Fatalf("OBS connection is not configured: set obs.address/obs.password, %s/%s or -obs-address/-obs-password",
+			config.EnvName("obs-address"), config.EnvName("obs-password"))
+	default:
 		reader := bufio.NewReader(os.Stdin)
 
 		hostname, err := prompt(reader, "Enter OBS WS hostname [default: localhost]: ")
@@ -96,17 +112,12 @@ func main() {
 			fmt.Println("Password is required. Please try again.")
 		}
 
-		options := &domain.ObsConnectionOptions{
+		options = &domain.ObsConnectionOptions{
 			Address:  fmt.Sprintf("%s:%s", hostname, port),
 			Password: password,
 		}
 
-		obs, err = goobs.New(options.Address, goobs.WithPassword(options.Password))
-		if err != nil {
-			panic(err)
-		}
-
-		st.Update(func(cur domain.State) domain.State {
+		st.Update("obs_options", func(cur domain.State) domain.State {
 			next := cur
 			next.ObsConnectionOptions = options
 
@@ -114,36 +125,66 @@ func main() {
 		})
 	}
 
-	defer obs.Disconnect()
-
 	snapshotter.RequestSave()
 
 	hub := stream.NewHub()
+	hub.BufferSize = cfg.Stream.ReplayBuffer
+	hub.SubscriberBuffer = cfg.Stream.SubscriberBuffer
+	hub.DefaultPolicy = cfg.Stream.DefaultPolicy
+	hub.Policies = cfg.Stream.Policies
+
+	templatesFS := web.Templates()
+	if cfg.Templates != "" {
+		templatesFS = os.DirFS(cfg.Templates)
+	}
 
-	renderer, err := render.NewRenderer()
+	renderer, err := render.NewRenderer(templatesFS)
 	if err != nil {
 		log.Fatalf("renderer init failed: %v", err)
 	}
 
-	addr := "127.0.0.1:8080"
-	baseUrl := &url.URL{Scheme: "http", Host: addr}
+	status := &handlers.StatusHandler{
+		Store:    st,
+		Hub:      hub,
+		Renderer: renderer,
+	}
 
-	replayBuilder := &replays.Builder{
+	screens := &handlers.ScreensHandler{
+		Store:    st,
+		Hub:      hub,
+		Renderer: renderer,
+	}
+
+	data := &handlers.DataHandler{
 		Store:   st,
-		BaseURL: baseUrl,
+		Hub:     hub,
+		Screens: screens,
+	}
+
+	// the server starts even if OBS is not up yet; the supervisor keeps retrying
+	obs := internalObs.NewSupervisor(options.Address, options.Password)
+	obs.OnStatus = status.OnObsStatus
+	go obs.Run(ctx)
+
+	baseUrl := &url.URL{Scheme: "http", Host: cfg.Listen}
+
+	replayBuilder := &replays.Builder{
+		Store:    st,
+		BaseURL:  baseUrl,
+		OnChange: data.PublishReplays,
 	}
 
-	const ffmpegBin = "ffmpeg.exe"
-	const ffprobeBin = "ffprobe.exe"
-	hl := highlighter.New(ffprobeBin, st, snapshotter, obs)
+	hl := highlighter.New(cfg.FFmpeg.ProbeBin, st, snapshotter, obs)
+	hl.OnChange = data.PublishReplays
 	defer hl.Close()
 
 	obsController := &internalObs.Controller{
 		StateStore:      st,
-		ReplaySceneName: "Replay",
-		VlcInputName:    "Replay Source",
+		ReplaySceneName: cfg.Replay.SceneName,
+		VlcInputName:    cfg.Replay.InputName,
 		Obs:             obs,
 		BaseURL:         baseUrl,
+		OnPlayback:      data.PublishReplays,
 	}
 
 	events := &handlers.EventsHandler{
@@ -156,19 +197,88 @@ func main() {
 		ObsController: obsController,
 	}
 
-	screens := &handlers.ScreensHandler{
-		Store:    st,
-		Hub:      hub,
-		Renderer: renderer,
+	if cfg.CapturePath != "" {
+		recorder, err := capture.NewRecorder(cfg.CapturePath)
+		if err != nil {
+			log.Fatalf("capture init failed: %v", err)
+		}
+		defer recorder.Close()
+		events.Recorder = recorder
+		log.Printf("capturing game events to %s", cfg.CapturePath)
+	}
+
+	var archive *persist.MatchArchive
+	if cfg.ArchiveDir != "" {
+		archive, err = persist.NewMatchArchive(cfg.ArchiveDir)
+		if err != nil {
+			log.Fatalf("match archive init failed: %v", err)
+		}
+		events.Archive = archive
+	}
+
+	admin := &handlers.AdminHandler{
+		Store:         st,
+		Snapshotter:   snapshotter,
+		ReplayBuilder: replayBuilder,
+		Archive:       archive,
+	}
+
+	control := &handlers.ControlHandler{
+		Store:         st,
+		Events:        events,
+		ObsController: obsController,
+		Hub:           hub,
+		Renderer:      renderer,
+	}
+	go control.Run(ctx, 250*time.Millisecond)
+
+	events.Data = data
+
+	debug := &handlers.DebugHandler{
+		Store:       st,
+		Hub:         hub,
+		Snapshotter: snapshotter,
+		Screens:     screens,
+		Data:        data,
+	}
+
+	ws := &handlers.WSHandler{
+		Hub:     hub,
+		Screens: screens,
+		Control: control,
+	}
+
+	if cfg.Dev {
+		go render.WatchDir(ctx, cfg.Templates, 500*time.Millisecond, func() {
+			if err := renderer.Reload(); err != nil {
+				log.Printf("templates reload failed: %v", err)
+				return
+			}
+			log.Printf("templates reloaded from %s", cfg.Templates)
+			screens.NotifyReload()
+		})
+	}
+
+	var replayCache *replays.Cache
+	if cfg.Cache.Dir != "" {
+		replayCache, err = replays.NewCache(cfg.Cache.Dir, cfg.Cache.MaxBytes, cfg.Cache.MaxAge)
+		if err != nil {
+			log.Fatalf("replay cache init failed: %v", err)
+		}
+		go replayCache.Run(ctx, cfg.Cache.SweepInterval)
 	}
 
 	replayStreamer := &replays.Streamer{
 		Store:                st,
 		ObsController:        obsController,
-		FFmpegBin:            ffmpegBin,
-		FFprobeBin:           ffprobeBin,
-		GameAudioStreamTitle: "Game only",
-		GameAudioStreamIndex: 3,
+		FFmpegBin:            cfg.FFmpeg.Bin,
+		FFprobeBin:           cfg.FFmpeg.ProbeBin,
+		GameAudioStreamTitle: cfg.Replay.AudioStreamTitle,
+		GameAudioStreamIndex: cfg.Replay.AudioStreamIndex,
+		EncoderProfile:       cfg.Encoder.Profile,
+		FallbackProfile:      cfg.Encoder.Fallback,
+		Encoders:             cfg.Encoder.Profiles,
+		Cache:                replayCache,
 	}
 
 	go func() {
@@ -190,13 +300,60 @@ func main() {
 	// screens
 	mux.HandleFunc("GET /screens/player_picks", screens.PlayerPicksPage)
 	mux.HandleFunc("GET /screens/match_info", screens.MatchInfoPage)
+	mux.HandleFunc("GET /screens/scoreboard", screens.ScoreboardPage)
+	mux.HandleFunc("GET /screens/kill_feed", screens.KillFeedPage)
+	mux.HandleFunc("GET /screens/obs_status", screens.ObsStatusPage)
 
 	// streams
 	mux.HandleFunc("GET /screens/player_picks/stream", screens.PlayerPicksStream)
 	mux.HandleFunc("GET /screens/match_info/stream", screens.MatchInfoStream)
+	mux.HandleFunc("GET /screens/scoreboard/stream", screens.ScoreboardStream)
+	mux.HandleFunc("GET /screens/kill_feed/stream", screens.KillFeedStream)
+	mux.HandleFunc("GET /screens/obs_status/stream", screens.ObsStatusStream)
+
+	// status
+	mux.HandleFunc("GET /status", status.HandleStatus)
 
 	// replays
 	mux.HandleFunc("GET /replay.ts", replayStreamer.HandleStream)
+	mux.HandleFunc("GET /replays/{id}/index.m3u8", replayStreamer.HandleHLSPlaylist)
+	mux.HandleFunc("GET /replays/{id}/{key}/{segment}", replayStreamer.HandleHLSSegment)
+	mux.HandleFunc("POST /replays/cache/evict", handlers.SameOrigin(replayStreamer.HandleCacheEvict))
+
+	// control
+	mux.HandleFunc("GET /control", screens.ControlPage)
+	mux.HandleFunc("GET /control/stream", screens.ControlStream)
+	mux.HandleFunc("POST /control/replay/start", handlers.SameOrigin(control.StartReplay))
+	mux.HandleFunc("POST /control/replay/stop", handlers.SameOrigin(control.StopReplay))
+	mux.HandleFunc("POST /control/replay/skip", handlers.SameOrigin(control.SkipReplay))
+
+	// admin api
+	mux.HandleFunc("GET /api/replays", admin.ListReplays)
+	mux.HandleFunc("POST /api/replays", handlers.SameOrigin(admin.CreateReplay))
+	mux.HandleFunc("GET /api/replays/{id}", admin.GetReplay)
+	mux.HandleFunc("DELETE /api/replays/{id}", handlers.SameOrigin(admin.DeleteReplay))
+	mux.HandleFunc("GET /api/replays/{id}/plan", replayStreamer.HandlePlan)
+	mux.HandleFunc("GET /api/highlights", admin.ListHighlights)
+	mux.HandleFunc("GET /api/highlights/{start}", admin.GetHighlight)
+	mux.HandleFunc("GET /api/highlights/{start}/thumbnail", replayStreamer.HandleHighlightThumbnail)
+	mux.HandleFunc("DELETE /api/highlights/{start}", handlers.SameOrigin(admin.DeleteHighlight))
+	mux.HandleFunc("POST /api/highlights/{start}/move", handlers.SameOrigin(admin.MoveHighlight))
+	mux.HandleFunc("GET /api/matches", admin.ListMatches)
+	mux.HandleFunc("GET /api/matches/{id}", admin.GetMatch)
+
+	// JSON data topics
+	mux.HandleFunc("GET /data", data.Index)
+	mux.HandleFunc("GET /data/{topic}", data.Get)
+	mux.HandleFunc("GET /data/{topic}/stream", data.Stream)
+
+	// websocket transport for screens and replay control
+	mux.HandleFunc("GET /ws", ws.Serve)
+
+	// debug
+	mux.HandleFunc("GET /debug/state/history", debug.StateHistory)
+	mux.HandleFunc("GET /debug/state/history/{version}", debug.StateAt)
+	mux.HandleFunc("POST /debug/state/history/{version}/restore", handlers.SameOrigin(debug.RestoreState))
+	mux.HandleFunc("GET /debug/hub", debug.HubStats)
 
 	handler := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
@@ -215,7 +372,7 @@ func main() {
 	}).Handler(mux)
 
 	srv := &http.Server{
-		Addr:              "127.0.0.1:8080",
+		Addr:              cfg.Listen,
 		Handler:           handler,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
@@ -0,0 +1,86 @@
+// simulate posts a synthetic match to a running server's game event
+// endpoint, for working on overlays and highlights without playing.
+//
+//	simulate -seed 7 -speed 10
+//	simulate -seed 7 -out match.jsonl   # write a capture for replay-session
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/capture"
+	"github.com/akayumeru/valreplayserver/internal/simulate"
+)
+
+func main() {
+	defaults := simulate.DefaultOptions()
+
+	target := flag.String("target", "http://127.0.0.1:8080/events/game_event", "game event endpoint to post to")
+	speed := flag.Float64("speed", 1, "playback speed multiplier; 0 sends without delays")
+	seed := flag.Uint64("seed", uint64(time.Now().UnixNano()), "random seed; the same seed produces the same match")
+	roundsToWin := flag.Int("rounds-to-win", defaults.RoundsToWin, "rounds needed to win the match")
+	maxRounds := flag.Int("max-rounds", 0, "stop after this many rounds (0 = play the match out)")
+	agentSelect := flag.Duration("agent-select", defaults.AgentSelect, "agent select length")
+	matchMap := flag.String("map", "", "map name (empty = random)")
+	out := flag.String("out", "", "write the match as a capture file instead of posting it")
+	verbose := flag.Bool("v", false, "log every payload")
+	flag.Parse()
+
+	entries := simulate.Match(simulate.Options{
+		Seed:        *seed,
+		RoundsToWin: *roundsToWin,
+		MaxRounds:   *maxRounds,
+		AgentSelect: *agentSelect,
+		Map:         *matchMap,
+	})
+	length := entries[len(entries)-1].At.Sub(entries[0].At)
+
+	if *out != "" {
+		if err := writeCapture(*out, entries); err != nil {
+			log.Fatalf("write %s: %v", *out, err)
+		}
+		log.Printf("seed %d: wrote %d payloads (%s of match) to %s", *seed, len(entries), length.Round(time.Second), *out)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	log.Printf("seed %d: simulating %d payloads (%s of match) to %s at %gx", *seed, len(entries), length.Round(time.Second), *target, *speed)
+
+	err := capture.Play(ctx, entries, *speed, 0, func(i int, e capture.Entry) error {
+		if *verbose {
+			log.Printf("[%d/%d] %s", i+1, len(entries), e.Payload())
+		}
+		return capture.Post(ctx, client, *target, e.Payload())
+	})
+	if err != nil {
+		log.Fatalf("simulation stopped: %v", err)
+	}
+
+	log.Printf("match finished")
+}
+
+func writeCapture(path string, entries []capture.Entry) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -4,13 +4,14 @@ go 1.25
 
 require (
 	github.com/andreykaipov/goobs v1.5.6
+	github.com/gorilla/websocket v1.5.3
 	github.com/rs/cors v1.11.1
 	github.com/sashka/atomicfile v0.0.0-20200525220301-56ae5a81ddac
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/buger/jsonparser v1.1.1 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/hashicorp/logutils v1.0.0 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/mmcloughlin/profile v0.1.1 // indirect
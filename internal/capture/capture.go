@@ -0,0 +1,152 @@
+// Package capture records raw game event payloads to a JSONL file and plays
+// them back, so a live session can be reproduced offline.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one received payload. Valid JSON bodies are stored as-is in Body;
+// anything else goes to Text.
+type Entry struct {
+	At   time.Time       `json:"at"`
+	Body json.RawMessage `json:"body,omitempty"`
+	Text string          `json:"text,omitempty"`
+}
+
+func (e Entry) Payload() []byte {
+	if len(e.Body) > 0 {
+		return e.Body
+	}
+	return []byte(e.Text)
+}
+
+type Recorder struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// NewRecorder appends to path, creating it if needed.
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Record writes one line and flushes it, so a crash loses at most the
+// payload being written.
+func (r *Recorder) Record(at time.Time, body []byte) error {
+	e := Entry{At: at.UTC()}
+	if json.Valid(body) {
+		e.Body = compact(body)
+	} else {
+		e.Text = string(body)
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return r.w.Flush()
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.w.Flush(); err != nil {
+		_ = r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+func compact(body []byte) json.RawMessage {
+	var b bytes.Buffer
+	if err := json.Compact(&b, body); err != nil {
+		return body
+	}
+	return b.Bytes()
+}
+
+// Read parses a capture; blank lines are skipped.
+func Read(r io.Reader) ([]Entry, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+
+	var entries []Entry
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func ReadFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Play calls send for every entry in order, sleeping for the recorded gap
+// divided by speed. speed <= 0 sends back to back. Gaps longer than maxGap
+// (if > 0) are shortened to maxGap before scaling.
+func Play(ctx context.Context, entries []Entry, speed float64, maxGap time.Duration, send func(i int, e Entry) error) error {
+	for i, e := range entries {
+		if i > 0 && speed > 0 {
+			gap := e.At.Sub(entries[i-1].At)
+			if maxGap > 0 && gap > maxGap {
+				gap = maxGap
+			}
+			if gap > 0 {
+				t := time.NewTimer(time.Duration(float64(gap) / speed))
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return ctx.Err()
+				case <-t.C:
+				}
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := send(i, e); err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
+	return nil
+}
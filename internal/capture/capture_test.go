@@ -0,0 +1,157 @@
+package capture
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/store"
+	"github.com/akayumeru/valreplayserver/internal/valorant"
+)
+
+var testPayloads = []string{
+	`{"match_info":{"match_id":"m-1"}}`,
+	"{\n  \"match_info\": {\"round_number\": \"1\", \"team\": \"attack\"}\n}",
+	`{"match_info":{"round_phase":"shopping"}}`,
+	`not json`,
+	`{"match_info":{"round_phase":"combat"}}`,
+	`{"match_info":{"round_phase":"end"}}`,
+	`{"match_info":{"score":{"won":1,"lost":0},"round_report":{"damage":150,"hit":5,"headshot":2,"final_headshot":true}}}`,
+	`{"match_info":{"round_number":"2"}}`,
+}
+
+// gameEventServer applies payloads to s like the server's game event
+// endpoint; payloads that do not apply leave the state alone.
+func gameEventServer(t *testing.T, s *store.StateStore) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "read failed", http.StatusBadRequest)
+			return
+		}
+		s.Update("game_event", func(cur domain.State) domain.State {
+			next, _, err := valorant.ApplyPayload(cur, body)
+			if err != nil {
+				return cur
+			}
+			return next
+		})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMain(m *testing.M) {
+	// ApplyPayload debug-logs every payload
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestRecordReadPlay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	rec, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 2, 15, 4, 5, 0, time.FixedZone("CET", 3600))
+	for i, p := range testPayloads {
+		if err := rec.Record(start.Add(time.Duration(i)*time.Second), []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(testPayloads) {
+		t.Fatalf("read %d entries, want %d", len(entries), len(testPayloads))
+	}
+	if got := entries[1].At; !got.Equal(start.Add(time.Second)) || got.Location() != time.UTC {
+		t.Errorf("entry 1 at %v, want %v in UTC", got, start.Add(time.Second))
+	}
+	if got := string(entries[1].Payload()); got != `{"match_info":{"round_number":"1","team":"attack"}}` {
+		t.Errorf("entry 1 = %s, want it compacted", got)
+	}
+	if entries[3].Body != nil || entries[3].Text != "not json" {
+		t.Errorf("entry 3 = %+v, want the text kept as is", entries[3])
+	}
+
+	s := store.NewStateStore(domain.State{})
+	srv := gameEventServer(t, s)
+	var sent int
+	err = Play(context.Background(), entries, 0, 0, func(i int, e Entry) error {
+		sent++
+		return Post(context.Background(), srv.Client(), srv.URL, e.Payload())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != len(entries) {
+		t.Errorf("sent %d entries, want %d", sent, len(entries))
+	}
+
+	mi := s.Get().MatchInfo
+	wantTeams := [2]domain.TeamState{{RoundsWon: 1, Side: "attack"}, {Side: "defense"}}
+	if mi.MatchID != "m-1" || [2]domain.TeamState{mi.Ally, mi.Enemy} != wantTeams || mi.AllyStartingSide != "attack" {
+		t.Errorf("match info = %+v, want m-1 from attack with a round won", mi)
+	}
+	if len(mi.Rounds) != 2 || mi.CurrentRound == nil || mi.CurrentRound != mi.Rounds[2] {
+		t.Fatalf("rounds = %v, current %v; want round 2 started", mi.Rounds, mi.CurrentRound)
+	}
+	r := mi.Rounds[1]
+	if r.LastPhase != "end" || r.Outcome != "win" || r.Report == nil || r.Report.Damage != 150 || r.EndedAt.IsZero() {
+		t.Errorf("round 1 = %+v, want it ended and won with its report", r)
+	}
+}
+
+func TestPlayStops(t *testing.T) {
+	entries := []Entry{
+		{At: time.Unix(0, 0), Text: "a"},
+		{At: time.Unix(60, 0), Text: "b"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var sent []string
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Play(ctx, entries, 1, 0, func(i int, e Entry) error {
+			sent = append(sent, e.Text)
+			cancel()
+			return nil
+		})
+	}()
+	select {
+	case err := <-errc:
+		if err != context.Canceled || !reflect.DeepEqual(sent, []string{"a"}) {
+			t.Errorf("err = %v, sent %v; want canceled after a", err, sent)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Play waited out the gap after cancel")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	err := Play(context.Background(), entries, 0, 0, func(i int, e Entry) error {
+		return Post(context.Background(), srv.Client(), srv.URL, e.Payload())
+	})
+	if err == nil || err.Error() != "entry 0: "+srv.URL+": 400 Bad Request" {
+		t.Errorf("err = %v, want the first entry's status", err)
+	}
+}
@@ -0,0 +1,372 @@
+// Package config loads server settings from defaults, an optional YAML file,
+// VRS_* environment variables and command-line flags, in that order of
+// precedence (later wins).
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/replays"
+	"github.com/akayumeru/valreplayserver/internal/store"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+	"gopkg.in/yaml.v3"
+)
+
+const EnvPrefix = "VRS_"
+
+type Config struct {
+	Listen string `yaml:"listen"`
+
+	StatePath        string        `yaml:"state_path"`
+	SnapshotDebounce time.Duration `yaml:"snapshot_debounce"`
+
+	// ArchiveDir keeps one file per finished match; empty disables it.
+	ArchiveDir string `yaml:"archive_dir"`
+
+	// StateHistory is how many state changes are kept for /debug/state/history.
+	// Each holds a full copy of the state.
+	StateHistory int `yaml:"state_history"`
+
+	Templates string `yaml:"templates"`
+	Dev       bool   `yaml:"dev"`
+
+	// NonInteractive disables stdin prompts; missing OBS credentials are an
+	// error instead. Use it when running as a service.
+	NonInteractive bool `yaml:"non_interactive"`
+
+	// CapturePath, when set, appends every game event payload to this JSONL
+	// file for cmd/replay-session.
+	CapturePath string `yaml:"capture_path"`
+
+	OBS     OBSConfig     `yaml:"obs"`
+	FFmpeg  FFmpegConfig  `yaml:"ffmpeg"`
+	Replay  ReplayConfig  `yaml:"replay"`
+	Cache   CacheConfig   `yaml:"cache"`
+	Encoder EncoderConfig `yaml:"encoder"`
+	Stream  StreamConfig  `yaml:"stream"`
+}
+
+type OBSConfig struct {
+	// Empty address falls back to the connection saved in the state file.
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+}
+
+type FFmpegConfig struct {
+	Bin      string `yaml:"bin"`
+	ProbeBin string `yaml:"probe_bin"`
+}
+
+type ReplayConfig struct {
+	SceneName        string `yaml:"scene_name"`
+	InputName        string `yaml:"input_name"`
+	AudioStreamTitle string `yaml:"audio_stream_title"`
+	AudioStreamIndex int    `yaml:"audio_stream_index"`
+}
+
+type CacheConfig struct {
+	Dir           string        `yaml:"dir"`
+	MaxBytes      int64         `yaml:"max_bytes"`
+	MaxAge        time.Duration `yaml:"max_age"`
+	SweepInterval time.Duration `yaml:"sweep_interval"`
+}
+
+type EncoderConfig struct {
+	Profile  string `yaml:"profile"`
+	Fallback string `yaml:"fallback"`
+	// Profiles add to the built-in profiles or override the fields they set.
+	// Names are not case sensitive; they are lowercased on load like -encoder
+	// is on lookup.
+	Profiles map[string]replays.EncoderProfile `yaml:"profiles"`
+}
+
+type StreamConfig struct {
+	// ReplayBuffer is how many recent messages per topic are kept for
+	// screens resuming with Last-Event-ID.
+	ReplayBuffer int `yaml:"replay_buffer"`
+	// SubscriberBuffer is the queue size of each connected screen.
+	SubscriberBuffer int                      `yaml:"subscriber_buffer"`
+	DefaultPolicy    stream.Policy            `yaml:"default_policy"`
+	Policies         map[string]stream.Policy `yaml:"policies"`
+}
+
+func Default() Config {
+	return Config{
+		Listen:           "127.0.0.1:8080",
+		StatePath:        "./state.json",
+		SnapshotDebounce: 3 * time.Second,
+		StateHistory:     store.DefaultHistoryLimit,
+		ArchiveDir:       "./matches",
+		FFmpeg: FFmpegConfig{
+			Bin:      "ffmpeg.exe",
+			ProbeBin: "ffprobe.exe",
+		},
+		Replay: ReplayConfig{
+			SceneName:        "Replay",
+			InputName:        "Replay Source",
+			AudioStreamTitle: "Game only",
+			AudioStreamIndex: 3,
+		},
+		Cache: CacheConfig{
+			Dir:           "./replay_cache",
+			MaxBytes:      5 << 30,
+			MaxAge:        24 * time.Hour,
+			SweepInterval: 10 * time.Minute,
+		},
+		Encoder: EncoderConfig{
+			Profile:  replays.EncoderNVENC,
+			Fallback: replays.EncoderCPU,
+		},
+		Stream: StreamConfig{
+			ReplayBuffer:     stream.DefaultBufferSize,
+			SubscriberBuffer: stream.DefaultSubscriberBuffer,
+			DefaultPolicy:    stream.PolicyDropOldest,
+			// each fragment replaces the previous one, except kill feed entries
+			Policies: map[string]stream.Policy{
+				"player_picks": stream.PolicyCoalesce,
+				"match_info":   stream.PolicyCoalesce,
+				"scoreboard":   stream.PolicyCoalesce,
+				"obs_status":   stream.PolicyCoalesce,
+				"control":      stream.PolicyCoalesce,
+				"data/*":       stream.PolicyCoalesce, // JSON data topics
+			},
+		},
+	}
+}
+
+func bindFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.Listen, "listen", c.Listen, "HTTP listen address (host:port)")
+	fs.StringVar(&c.StatePath, "state", c.StatePath, "state snapshot file")
+	fs.DurationVar(&c.SnapshotDebounce, "snapshot-debounce", c.SnapshotDebounce, "delay before writing the state snapshot")
+	fs.StringVar(&c.ArchiveDir, "archive-dir", c.ArchiveDir, "directory for finished match archives (empty = off)")
+	fs.IntVar(&c.StateHistory, "state-history", c.StateHistory, "state changes kept for /debug/state/history (0 = off)")
+	fs.StringVar(&c.Templates, "templates", c.Templates, "load templates from this directory instead of the embedded copy")
+	fs.BoolVar(&c.Dev, "dev", c.Dev, "watch the -templates directory and reload screens on change")
+	fs.BoolVar(&c.NonInteractive, "non-interactive", c.NonInteractive, "never prompt on stdin; fail if OBS credentials are missing")
+
+	fs.StringVar(&c.CapturePath, "capture", c.CapturePath, "append raw game event payloads to this JSONL file")
+
+	fs.StringVar(&c.OBS.Address, "obs-address", c.OBS.Address, "obs-websocket host:port")
+	fs.StringVar(&c.OBS.Password, "obs-password", c.OBS.Password, "obs-websocket password")
+
+	fs.StringVar(&c.FFmpeg.Bin, "ffmpeg", c.FFmpeg.Bin, "ffmpeg binary")
+	fs.StringVar(&c.FFmpeg.ProbeBin, "ffprobe", c.FFmpeg.ProbeBin, "ffprobe binary")
+
+	fs.StringVar(&c.Replay.SceneName, "replay-scene", c.Replay.SceneName, "OBS scene shown during replays")
+	fs.StringVar(&c.Replay.InputName, "replay-input", c.Replay.InputName, "OBS VLC input that plays replays")
+	fs.StringVar(&c.Replay.AudioStreamTitle, "audio-track-title", c.Replay.AudioStreamTitle, "title of the game-only audio track in recordings")
+	fs.IntVar(&c.Replay.AudioStreamIndex, "audio-track-index", c.Replay.AudioStreamIndex, "audio track index used when no track matches -audio-track-title")
+
+	fs.StringVar(&c.Cache.Dir, "cache-dir", c.Cache.Dir, "replay render cache directory")
+	fs.Int64Var(&c.Cache.MaxBytes, "cache-max-bytes", c.Cache.MaxBytes, "replay cache size limit (0 = unlimited)")
+	fs.DurationVar(&c.Cache.MaxAge, "cache-max-age", c.Cache.MaxAge, "replay cache entry lifetime (0 = forever)")
+	fs.DurationVar(&c.Cache.SweepInterval, "cache-sweep-interval", c.Cache.SweepInterval, "how often the replay cache is trimmed")
+
+	fs.StringVar(&c.Encoder.Profile, "encoder", c.Encoder.Profile, "encoder profile: "+strings.Join(replays.EncoderProfileNames(nil), ", "))
+	fs.StringVar(&c.Encoder.Fallback, "encoder-fallback", c.Encoder.Fallback, "encoder profile used when -encoder fails (empty = none)")
+
+	fs.IntVar(&c.Stream.ReplayBuffer, "stream-replay-buffer", c.Stream.ReplayBuffer, "messages kept per topic for resuming screens")
+	fs.IntVar(&c.Stream.SubscriberBuffer, "stream-subscriber-buffer", c.Stream.SubscriberBuffer, "queued messages per connected screen")
+	fs.StringVar((*string)(&c.Stream.DefaultPolicy), "stream-policy", string(c.Stream.DefaultPolicy), "slow screen policy for topics without one: drop-oldest, coalesce-latest, disconnect")
+}
+
+// EnvName maps a flag name to its environment variable, e.g.
+// obs-address -> VRS_OBS_ADDRESS.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load builds the configuration for args (without the program name).
+// The file comes from -config or VRS_CONFIG; without one only defaults,
+// env and flags apply.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	// first pass only finds -config and rejects unknown flags; values are
+	// re-applied on top of the file and env below
+	probe := Default()
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", "", "YAML config file (env "+EnvName("config")+")")
+	bindFlags(fs, &probe)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of server:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nEvery flag can also be set with %s<FLAG_NAME>, e.g. %s.\n", EnvPrefix, EnvName("obs-address"))
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg := Default()
+
+	path := *configPath
+	if path == "" {
+		path, _ = lookupEnv(EnvName("config"))
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	apply := flag.NewFlagSet("apply", flag.ContinueOnError)
+	apply.SetOutput(io.Discard)
+	bindFlags(apply, &cfg)
+
+	var errs []error
+	apply.VisitAll(func(f *flag.Flag) {
+		name := EnvName(f.Name)
+		if v, ok := lookupEnv(name); ok {
+			if err := apply.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	})
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		if err := apply.Set(f.Name, f.Value.String()); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func loadFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+
+	profiles, err := lowerProfileNames(cfg.Encoder.Profiles)
+	if err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	cfg.Encoder.Profiles = profiles
+	return nil
+}
+
+func lowerProfileNames(profiles map[string]replays.EncoderProfile) (map[string]replays.EncoderProfile, error) {
+	if profiles == nil {
+		return nil, nil
+	}
+
+	out := make(map[string]replays.EncoderProfile, len(profiles))
+	for name, p := range profiles {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("encoder.profiles: %q is defined twice", key)
+		}
+		out[key] = p
+	}
+	return out, nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		fail("listen: %v", err)
+	}
+	if c.StatePath == "" {
+		fail("state_path is required")
+	}
+	if c.SnapshotDebounce <= 0 {
+		fail("snapshot_debounce must be positive")
+	}
+	if c.StateHistory < 0 {
+		fail("state_history must not be negative")
+	}
+	if c.Dev && c.Templates == "" {
+		fail("dev requires templates")
+	}
+
+	if c.OBS.Address != "" {
+		if _, _, err := net.SplitHostPort(c.OBS.Address); err != nil {
+			fail("obs.address: %v", err)
+		}
+	}
+
+	if c.FFmpeg.Bin == "" {
+		fail("ffmpeg.bin is required")
+	}
+	if c.FFmpeg.ProbeBin == "" {
+		fail("ffmpeg.probe_bin is required")
+	}
+
+	if c.Replay.SceneName == "" {
+		fail("replay.scene_name is required")
+	}
+	if c.Replay.InputName == "" {
+		fail("replay.input_name is required")
+	}
+	if c.Replay.AudioStreamIndex < 0 {
+		fail("replay.audio_stream_index must not be negative")
+	}
+
+	if c.Cache.Dir != "" {
+		if c.Cache.MaxBytes < 0 {
+			fail("cache.max_bytes must not be negative")
+		}
+		if c.Cache.MaxAge < 0 {
+			fail("cache.max_age must not be negative")
+		}
+		if c.Cache.SweepInterval <= 0 {
+			fail("cache.sweep_interval must be positive")
+		}
+	}
+
+	if _, err := replays.LookupEncoderProfile(c.Encoder.Profile, c.Encoder.Profiles); err != nil {
+		fail("encoder.profile: %v", err)
+	}
+	if c.Encoder.Fallback != "" {
+		if _, err := replays.LookupEncoderProfile(c.Encoder.Fallback, c.Encoder.Profiles); err != nil {
+			fail("encoder.fallback: %v", err)
+		}
+	}
+	for _, name := range replays.EncoderProfileNames(c.Encoder.Profiles) {
+		if _, err := replays.LookupEncoderProfile(name, c.Encoder.Profiles); err != nil {
+			fail("encoder.profiles.%s: %v", name, err)
+		}
+	}
+
+	if c.Stream.ReplayBuffer < 0 {
+		fail("stream.replay_buffer must not be negative")
+	}
+	if c.Stream.SubscriberBuffer < 0 {
+		fail("stream.subscriber_buffer must not be negative")
+	}
+	if !c.Stream.DefaultPolicy.Valid() {
+		fail("stream.default_policy: unknown policy %q", c.Stream.DefaultPolicy)
+	}
+	for topic, p := range c.Stream.Policies {
+		if !p.Valid() {
+			fail("stream.policies.%s: unknown policy %q", topic, p)
+		}
+	}
+
+	return errors.Join(errs...)
+}
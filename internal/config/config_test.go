@@ -0,0 +1,268 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/replays"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfig(t, `
+listen: 127.0.0.1:1001
+state_history: 10
+cache:
+  max_age: 1h
+stream:
+  default_policy: disconnect
+`)
+	other := writeConfig(t, "listen: 127.0.0.1:1002\n")
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want func(*Config)
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name: "file over defaults",
+			args: []string{"-config", file},
+			want: func(c *Config) {
+				c.Listen = "127.0.0.1:1001"
+				c.StateHistory = 10
+				c.Cache.MaxAge = time.Hour
+				c.Stream.DefaultPolicy = stream.PolicyDisconnect
+			},
+		},
+		{
+			name: "file from env",
+			env:  map[string]string{"VRS_CONFIG": other},
+			want: func(c *Config) { c.Listen = "127.0.0.1:1002" },
+		},
+		{
+			name: "-config over VRS_CONFIG",
+			args: []string{"-config", other},
+			env:  map[string]string{"VRS_CONFIG": file},
+			want: func(c *Config) { c.Listen = "127.0.0.1:1002" },
+		},
+		{
+			name: "env over file",
+			args: []string{"-config", file},
+			env:  map[string]string{"VRS_LISTEN": "127.0.0.1:2001", "VRS_CACHE_MAX_AGE": "2h"},
+			want: func(c *Config) {
+				c.Listen = "127.0.0.1:2001"
+				c.StateHistory = 10
+				c.Cache.MaxAge = 2 * time.Hour
+				c.Stream.DefaultPolicy = stream.PolicyDisconnect
+			},
+		},
+		{
+			name: "flag over env",
+			args: []string{"-config", file, "-listen", "127.0.0.1:3001", "-state-history", "0"},
+			env:  map[string]string{"VRS_LISTEN": "127.0.0.1:2001", "VRS_STATE_HISTORY": "20"},
+			want: func(c *Config) {
+				c.Listen = "127.0.0.1:3001"
+				c.StateHistory = 0
+				c.Cache.MaxAge = time.Hour
+				c.Stream.DefaultPolicy = stream.PolicyDisconnect
+			},
+		},
+		{
+			name: "flag set to the default still wins",
+			args: []string{"-config", file, "-stream-policy", "drop-oldest"},
+			want: func(c *Config) {
+				c.Listen = "127.0.0.1:1001"
+				c.StateHistory = 10
+				c.Cache.MaxAge = time.Hour
+			},
+		},
+		{
+			name: "env without a file",
+			env:  map[string]string{"VRS_DEV": "true", "VRS_TEMPLATES": "./web/templates", "VRS_OBS_ADDRESS": "localhost:4455"},
+			want: func(c *Config) {
+				c.Dev = true
+				c.Templates = "./web/templates"
+				c.OBS.Address = "localhost:4455"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.args, envLookup(tt.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := Default()
+			if tt.want != nil {
+				tt.want(&want)
+			}
+			if got.Listen != want.Listen || got.StateHistory != want.StateHistory || got.Cache != want.Cache ||
+				got.Stream.DefaultPolicy != want.Stream.DefaultPolicy || got.Dev != want.Dev ||
+				got.Templates != want.Templates || got.OBS != want.OBS {
+				t.Errorf("got %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	unknownKey := writeConfig(t, "listen: 127.0.0.1:1001\nlisten_port: 8080\n")
+	invalid := writeConfig(t, "state_history: -1\n")
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{name: "unknown flag", args: []string{"-nope"}, want: "flag provided but not defined"},
+		{name: "extra argument", args: []string{"serve"}, want: "unexpected arguments"},
+		{name: "missing file", args: []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, want: "no such file"},
+		{name: "unknown key", args: []string{"-config", unknownKey}, want: "listen_port"},
+		{name: "invalid env", env: map[string]string{"VRS_STATE_HISTORY": "many"}, want: "VRS_STATE_HISTORY"},
+		{name: "invalid flag", args: []string{"-snapshot-debounce", "soon"}, want: "invalid value"},
+		{name: "validated after the file", args: []string{"-config", invalid}, want: "state_history must not be negative"},
+		{name: "validated after env", env: map[string]string{"VRS_STREAM_POLICY": "drop-newest"}, want: "stream.default_policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.args, envLookup(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := Load([]string{"-h"}, envLookup(nil)); err != flag.ErrHelp {
+		t.Errorf("-h: err = %v, want flag.ErrHelp", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults are invalid: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*Config)
+		want   string
+	}{
+		{"listen without port", func(c *Config) { c.Listen = "localhost" }, "listen:"},
+		{"no state path", func(c *Config) { c.StatePath = "" }, "state_path is required"},
+		{"zero debounce", func(c *Config) { c.SnapshotDebounce = 0 }, "snapshot_debounce must be positive"},
+		{"negative history", func(c *Config) { c.StateHistory = -1 }, "state_history must not be negative"},
+		{"dev without templates", func(c *Config) { c.Dev = true }, "dev requires templates"},
+		{"obs address without port", func(c *Config) { c.OBS.Address = "localhost" }, "obs.address:"},
+		{"no ffmpeg", func(c *Config) { c.FFmpeg.Bin = "" }, "ffmpeg.bin is required"},
+		{"no ffprobe", func(c *Config) { c.FFmpeg.ProbeBin = "" }, "ffmpeg.probe_bin is required"},
+		{"no scene", func(c *Config) { c.Replay.SceneName = "" }, "replay.scene_name is required"},
+		{"no input", func(c *Config) { c.Replay.InputName = "" }, "replay.input_name is required"},
+		{"negative audio index", func(c *Config) { c.Replay.AudioStreamIndex = -1 }, "replay.audio_stream_index must not be negative"},
+		{"negative cache size", func(c *Config) { c.Cache.MaxBytes = -1 }, "cache.max_bytes must not be negative"},
+		{"negative cache age", func(c *Config) { c.Cache.MaxAge = -time.Second }, "cache.max_age must not be negative"},
+		{"zero sweep interval", func(c *Config) { c.Cache.SweepInterval = 0 }, "cache.sweep_interval must be positive"},
+		{"unknown encoder", func(c *Config) { c.Encoder.Profile = "quicksync" }, "encoder.profile:"},
+		{"unknown fallback", func(c *Config) { c.Encoder.Fallback = "quicksync" }, "encoder.fallback:"},
+		{"profile without a codec", func(c *Config) {
+			c.Encoder.Profiles = map[string]replays.EncoderProfile{"custom": {Bitrate: "8M"}}
+		}, "encoder.profiles.custom: encoder profile \"custom\" has no codec"},
+		{"negative replay buffer", func(c *Config) { c.Stream.ReplayBuffer = -1 }, "stream.replay_buffer must not be negative"},
+		{"negative subscriber buffer", func(c *Config) { c.Stream.SubscriberBuffer = -1 }, "stream.subscriber_buffer must not be negative"},
+		{"unknown default policy", func(c *Config) { c.Stream.DefaultPolicy = "drop-newest" }, "stream.default_policy"},
+		{"unknown topic policy", func(c *Config) { c.Stream.Policies = map[string]stream.Policy{"kill_feed": "x"} }, "stream.policies.kill_feed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.mutate(&c)
+			err := c.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+
+	// the cache limits only matter with a cache
+	c := Default()
+	c.Cache.Dir = ""
+	c.Cache.SweepInterval = 0
+	if err := c.Validate(); err != nil {
+		t.Errorf("disabled cache: %v", err)
+	}
+
+	c = Default()
+	c.Encoder.Profile = "custom"
+	c.Encoder.Fallback = ""
+	c.Encoder.Profiles = map[string]replays.EncoderProfile{"custom": {Codec: "libx265"}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("profile from encoder.profiles: %v", err)
+	}
+
+	c = Default()
+	c.StatePath = ""
+	c.FFmpeg.Bin = ""
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "state_path") || !strings.Contains(err.Error(), "ffmpeg.bin") {
+		t.Errorf("err = %v, want every problem reported", err)
+	}
+}
+
+func TestLoadEncoderProfileNames(t *testing.T) {
+	file := writeConfig(t, `
+encoder:
+  profile: Studio
+  fallback: CPU
+  profiles:
+    " Studio ":
+      codec: h264_nvenc
+      hardware: true
+`)
+	cfg, err := Load([]string{"-config", file}, envLookup(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := replays.LookupEncoderProfile(cfg.Encoder.Profile, cfg.Encoder.Profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "studio" || p.Codec != "h264_nvenc" {
+		t.Errorf("profile = %+v, want studio from encoder.profiles", p)
+	}
+
+	dup := writeConfig(t, `
+encoder:
+  profiles:
+    cpu: {codec: libx264}
+    CPU: {codec: libx265}
+`)
+	if _, err := Load([]string{"-config", dup}, envLookup(nil)); err == nil || !strings.Contains(err.Error(), `"cpu" is defined twice`) {
+		t.Errorf("err = %v, want the duplicate reported", err)
+	}
+}
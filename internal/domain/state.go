@@ -38,6 +38,44 @@ type MatchInfo struct {
 
 	Roster   map[string]RosterPlayer `json:"roster"`
 	KillFeed []KillFeedEntry         `json:"killFeed"`
+
+	// Ally is the local player's team.
+	Ally             TeamState                  `json:"ally"`
+	Enemy            TeamState                  `json:"enemy"`
+	AllyStartingSide string                     `json:"allyStartingSide"`
+	Half             int                        `json:"half"`
+	Overtime         bool                       `json:"overtime"`
+	MatchOutcome     string                     `json:"matchOutcome"` // victory / defeat
+	Scoreboard       map[string]ScoreboardEntry `json:"scoreboard"`
+}
+
+type TeamState struct {
+	RoundsWon int    `json:"roundsWon"`
+	Side      string `json:"side"` // attack / defense
+}
+
+type ScoreboardEntry struct {
+	PlayerID  string `json:"player_id"`
+	Name      string `json:"name"`
+	Character string `json:"character"`
+	Teammate  bool   `json:"teammate"`
+	Alive     bool   `json:"alive"`
+	Kills     int    `json:"kills"`
+	Deaths    int    `json:"deaths"`
+	Assists   int    `json:"assists"`
+	Money     int    `json:"money"`
+	UltPoints int    `json:"ult_points"`
+	UltMax    int    `json:"ult_max"`
+	Shield    int    `json:"shield"`
+	Weapon    string `json:"weapon"`
+	IsLocal   bool   `json:"is_local"`
+}
+
+type RoundReport struct {
+	Damage        int  `json:"damage"`
+	Hit           int  `json:"hit"`
+	Headshot      int  `json:"headshot"`
+	FinalHeadshot bool `json:"final_headshot"`
 }
 
 type KillFeedEntry struct {
@@ -69,6 +107,20 @@ type Round struct {
 
 	LastPhase      string    `json:"lastPhase"`
 	PhaseStartedAt time.Time `json:"phaseStartedAt"`
+
+	Outcome string       `json:"outcome"` // win / loss, local player's team perspective
+	Report  *RoundReport `json:"report"`
+
+	SpikePlantedAt   time.Time `json:"spikePlantedAt"`
+	SpikeDefusedAt   time.Time `json:"spikeDefusedAt"`
+	SpikeDetonatedAt time.Time `json:"spikeDetonatedAt"`
+
+	// local player's stats for the round
+	Kills     int `json:"kills"`
+	Deaths    int `json:"deaths"`
+	Assists   int `json:"assists"`
+	Headshots int `json:"headshots"`
+	Damage    int `json:"damage"`
 }
 
 type PlayerInfo struct {
@@ -86,6 +138,23 @@ type ObsConnectionOptions struct {
 	Password string `json:"password"`
 }
 
+type ObsStatus struct {
+	State     string    `json:"state"` // disconnected / connecting / connected
+	Address   string    `json:"address"`
+	Since     time.Time `json:"since"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError,omitempty"`
+
+	ReplayBuffer bool `json:"replayBuffer"` // replay buffer output active, as last reported by OBS
+}
+
+// Playback is the replay currently on air, if any.
+type Playback struct {
+	Playing   bool      `json:"playing"`
+	ReplayID  uint32    `json:"replayId"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
 type State struct {
 	ObsConnectionOptions *ObsConnectionOptions `json:"obsConnectionOptions"`
 	UpdatedAt            time.Time             `json:"updatedAt"`
@@ -93,4 +162,91 @@ type State struct {
 	GameInfo             GameInfo              `json:"gameInfo"`
 	MatchInfo            MatchInfo             `json:"matchInfo"`
 	ReplayState          ReplayState           `json:"replayState"`
+
+	// runtime only, not persisted
+	ObsStatus ObsStatus `json:"-"`
+	Playback  Playback  `json:"-"`
+
+	// Version is the store version that produced this state.
+	Version uint64 `json:"-"`
+}
+
+// Clone returns a deep copy of s. Rounds and highlights are shared by
+// pointer and updated in place, so anything that keeps a state around
+// after the store moves on needs its own copy.
+func (s State) Clone() State {
+	out := s
+
+	if s.ObsConnectionOptions != nil {
+		o := *s.ObsConnectionOptions
+		out.ObsConnectionOptions = &o
+	}
+
+	mi := &out.MatchInfo
+	if s.MatchInfo.Rounds != nil {
+		mi.Rounds = make(map[int]*Round, len(s.MatchInfo.Rounds))
+		for n, r := range s.MatchInfo.Rounds {
+			mi.Rounds[n] = r.clone()
+		}
+	}
+	if cr := s.MatchInfo.CurrentRound; cr != nil {
+		// keep CurrentRound pointing into Rounds, as the publisher expects
+		if r, ok := mi.Rounds[cr.Number]; ok && s.MatchInfo.Rounds[cr.Number] == cr {
+			mi.CurrentRound = r
+		} else {
+			mi.CurrentRound = cr.clone()
+		}
+	}
+	if s.MatchInfo.Roster != nil {
+		mi.Roster = make(map[string]RosterPlayer, len(s.MatchInfo.Roster))
+		for id, p := range s.MatchInfo.Roster {
+			mi.Roster[id] = p
+		}
+	}
+	if s.MatchInfo.Scoreboard != nil {
+		mi.Scoreboard = make(map[string]ScoreboardEntry, len(s.MatchInfo.Scoreboard))
+		for id, e := range s.MatchInfo.Scoreboard {
+			mi.Scoreboard[id] = e
+		}
+	}
+	if s.MatchInfo.KillFeed != nil {
+		mi.KillFeed = append([]KillFeedEntry(nil), s.MatchInfo.KillFeed...)
+	}
+
+	rs := &out.ReplayState
+	rs.PendingHighlights = cloneHighlights(s.ReplayState.PendingHighlights)
+	if s.ReplayState.Replays != nil {
+		rs.Replays = make(map[uint32]Replay, len(s.ReplayState.Replays))
+		for id, r := range s.ReplayState.Replays {
+			r.Highlights = cloneHighlights(r.Highlights)
+			rs.Replays[id] = r
+		}
+	}
+
+	return out
+}
+
+func (r *Round) clone() *Round {
+	c := *r
+	if r.Report != nil {
+		rep := *r.Report
+		c.Report = &rep
+	}
+	return &c
+}
+
+func cloneHighlights(hs []*Highlight) []*Highlight {
+	if hs == nil {
+		return nil
+	}
+	out := make([]*Highlight, len(hs))
+	for i, h := range hs {
+		if h == nil {
+			continue
+		}
+		c := *h
+		c.EventsTimestamps = append([]uint64(nil), h.EventsTimestamps...)
+		out[i] = &c
+	}
+	return out
 }
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/persist"
+	"github.com/akayumeru/valreplayserver/internal/replays"
+	"github.com/akayumeru/valreplayserver/internal/store"
+)
+
+// AdminHandler is the JSON API used to curate replays and pending highlights.
+// Highlights are addressed by their StartTime, which is unique per buffer save.
+type AdminHandler struct {
+	Store         *store.StateStore
+	Snapshotter   *persist.Snapshotter
+	ReplayBuilder *replays.Builder
+	// Archive is nil when match archiving is disabled
+	Archive *persist.MatchArchive
+}
+
+type replaySummary struct {
+	ID          uint32 `json:"id"`
+	RoundNumber int    `json:"roundNumber"`
+	Highlights  int    `json:"highlights"`
+	URL         string `json:"url"`
+}
+
+type replayDetail struct {
+	ID          uint32              `json:"id"`
+	RoundNumber int                 `json:"roundNumber"`
+	URL         string              `json:"url"`
+	Highlights  []*domain.Highlight `json:"highlights"`
+}
+
+func (h *AdminHandler) ListReplays(w http.ResponseWriter, r *http.Request) {
+	st := h.Store.Get()
+
+	out := make([]replaySummary, 0, len(st.ReplayState.Replays))
+	for id, replay := range st.ReplayState.Replays {
+		out = append(out, replaySummary{
+			ID:          id,
+			RoundNumber: replay.RoundNumber,
+			Highlights:  len(nonNilHighlights(replay.Highlights)),
+			URL:         h.ReplayBuilder.ReplayURL(id),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *AdminHandler) GetReplay(w http.ResponseWriter, r *http.Request) {
+	id, ok := replayIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	replay, found := h.Store.Get().ReplayState.Replays[id]
+	if !found {
+		http.Error(w, replays.ErrReplayNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, replayDetail{
+		ID:          id,
+		RoundNumber: replay.RoundNumber,
+		URL:         h.ReplayBuilder.ReplayURL(id),
+		Highlights:  nonNilHighlights(replay.Highlights),
+	})
+}
+
+// CreateReplay turns the pending highlights into a new replay.
+func (h *AdminHandler) CreateReplay(w http.ResponseWriter, r *http.Request) {
+	id, u, err := h.ReplayBuilder.CreateReplay()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	h.Snapshotter.RequestSave()
+
+	replay := h.Store.Get().ReplayState.Replays[id]
+	writeJSON(w, http.StatusCreated, replayDetail{
+		ID:          id,
+		RoundNumber: replay.RoundNumber,
+		URL:         u,
+		Highlights:  nonNilHighlights(replay.Highlights),
+	})
+}
+
+func (h *AdminHandler) DeleteReplay(w http.ResponseWriter, r *http.Request) {
+	id, ok := replayIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.ReplayBuilder.DeleteReplay(id); err != nil {
+		writeBuilderError(w, err)
+		return
+	}
+	h.Snapshotter.RequestSave()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) ListHighlights(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, nonNilHighlights(h.Store.Get().ReplayState.PendingHighlights))
+}
+
+func (h *AdminHandler) GetHighlight(w http.ResponseWriter, r *http.Request) {
+	start, ok := highlightParam(w, r)
+	if !ok {
+		return
+	}
+
+	hl, err := h.ReplayBuilder.Highlight(start)
+	if err != nil {
+		writeBuilderError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, hl)
+}
+
+func (h *AdminHandler) DeleteHighlight(w http.ResponseWriter, r *http.Request) {
+	start, ok := highlightParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.ReplayBuilder.DeleteHighlight(start); err != nil {
+		writeBuilderError(w, err)
+		return
+	}
+	h.Snapshotter.RequestSave()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type moveHighlightRequest struct {
+	// nil moves the highlight back to the pending list
+	ReplayID *uint32 `json:"replayId"`
+}
+
+// MoveHighlight moves a highlight (pending or in any replay) into another
+// replay or back to pending.
+func (h *AdminHandler) MoveHighlight(w http.ResponseWriter, r *http.Request) {
+	start, ok := highlightParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req moveHighlightRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.ReplayBuilder.MoveHighlight(start, req.ReplayID); err != nil {
+		writeBuilderError(w, err)
+		return
+	}
+	h.Snapshotter.RequestSave()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func replayIDParam(w http.ResponseWriter, r *http.Request) (uint32, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "invalid replay id", http.StatusBadRequest)
+		return 0, false
+	}
+	return uint32(id), true
+}
+
+func highlightParam(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	start, err := strconv.ParseUint(r.PathValue("start"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid highlight start time", http.StatusBadRequest)
+		return 0, false
+	}
+	return start, true
+}
+
+func writeBuilderError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, replays.ErrReplayNotFound), errors.Is(err, replays.ErrHighlightNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func nonNilHighlights(hls []*domain.Highlight) []*domain.Highlight {
+	out := make([]*domain.Highlight, 0, len(hls))
+	for _, hl := range hls {
+		if hl != nil {
+			out = append(out, hl)
+		}
+	}
+	return out
+}
+
+func (h *AdminHandler) ListMatches(w http.ResponseWriter, r *http.Request) {
+	if h.Archive == nil {
+		http.Error(w, "match archive disabled", http.StatusNotFound)
+		return
+	}
+
+	out, err := h.Archive.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *AdminHandler) GetMatch(w http.ResponseWriter, r *http.Request) {
+	if h.Archive == nil {
+		http.Error(w, "match archive disabled", http.StatusNotFound)
+		return
+	}
+
+	m, err := h.Archive.Load(r.PathValue("id"))
+	if errors.Is(err, persist.ErrMatchNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/persist"
+	"github.com/akayumeru/valreplayserver/internal/replays"
+	"github.com/akayumeru/valreplayserver/internal/store"
+)
+
+type adminTestServer struct {
+	*httptest.Server
+	store *store.StateStore
+}
+
+// newAdminTestServer serves the admin API over st, routed as in main.
+func newAdminTestServer(t *testing.T, st domain.State, archive *persist.MatchArchive) *adminTestServer {
+	t.Helper()
+
+	s := store.NewStateStore(st)
+	base, _ := url.Parse("http://replays.test")
+	h := &AdminHandler{
+		Store:         s,
+		Snapshotter:   persist.NewSnapshotter(filepath.Join(t.TempDir(), "state.json"), s, time.Second),
+		ReplayBuilder: &replays.Builder{Store: s, BaseURL: base},
+		Archive:       archive,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/replays", h.ListReplays)
+	mux.HandleFunc("POST /api/replays", h.CreateReplay)
+	mux.HandleFunc("GET /api/replays/{id}", h.GetReplay)
+	mux.HandleFunc("DELETE /api/replays/{id}", h.DeleteReplay)
+	mux.HandleFunc("GET /api/highlights", h.ListHighlights)
+	mux.HandleFunc("GET /api/highlights/{start}", h.GetHighlight)
+	mux.HandleFunc("DELETE /api/highlights/{start}", h.DeleteHighlight)
+	mux.HandleFunc("POST /api/highlights/{start}/move", h.MoveHighlight)
+	mux.HandleFunc("GET /api/matches", h.ListMatches)
+	mux.HandleFunc("GET /api/matches/{id}", h.GetMatch)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return &adminTestServer{Server: srv, store: s}
+}
+
+// do sends the request and decodes a JSON response into out, if given.
+func (s *adminTestServer) do(t *testing.T, method, path, body string, out any) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	} else {
+		_, _ = io.Copy(io.Discard, resp.Body)
+	}
+	return resp.StatusCode
+}
+
+func adminTestState() domain.State {
+	return domain.State{
+		MatchInfo: domain.MatchInfo{CurrentRound: &domain.Round{Number: 5}},
+		ReplayState: domain.ReplayState{
+			CurrentReplayId: 3,
+			PendingHighlights: []*domain.Highlight{
+				{StartTime: 500, Round: 5},
+				{StartTime: 400, Round: 5},
+			},
+			Replays: map[uint32]domain.Replay{
+				2: {RoundNumber: 2, Highlights: []*domain.Highlight{{StartTime: 200}, nil}},
+				1: {RoundNumber: 1, Highlights: []*domain.Highlight{{StartTime: 100}, {StartTime: 110}}},
+			},
+		},
+	}
+}
+
+func highlightStarts(hls []*domain.Highlight) []uint64 {
+	out := make([]uint64, 0, len(hls))
+	for _, hl := range hls {
+		out = append(out, hl.StartTime)
+	}
+	return out
+}
+
+func TestAdminReplays(t *testing.T) {
+	srv := newAdminTestServer(t, adminTestState(), nil)
+
+	var list []replaySummary
+	if code := srv.do(t, "GET", "/api/replays", "", &list); code != http.StatusOK {
+		t.Fatalf("list: %d", code)
+	}
+	want := []replaySummary{
+		{ID: 1, RoundNumber: 1, Highlights: 2, URL: "http://replays.test/replay.ts?replay_id=1"},
+		{ID: 2, RoundNumber: 2, Highlights: 1, URL: "http://replays.test/replay.ts?replay_id=2"},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("list = %+v\nwant %+v", list, want)
+	}
+
+	var detail replayDetail
+	if code := srv.do(t, "GET", "/api/replays/2", "", &detail); code != http.StatusOK {
+		t.Fatalf("get: %d", code)
+	}
+	if !reflect.DeepEqual(highlightStarts(detail.Highlights), []uint64{200}) {
+		t.Errorf("replay 2 highlights = %v, want [200] without the nil", highlightStarts(detail.Highlights))
+	}
+
+	var created replayDetail
+	if code := srv.do(t, "POST", "/api/replays", "", &created); code != http.StatusCreated {
+		t.Fatalf("create: %d", code)
+	}
+	if created.ID != 3 || created.RoundNumber != 5 || !reflect.DeepEqual(highlightStarts(created.Highlights), []uint64{400, 500}) {
+		t.Errorf("created %+v, want replay 3 of round 5 with [400 500]", created)
+	}
+	// nothing pending anymore
+	if code := srv.do(t, "POST", "/api/replays", "", nil); code != http.StatusConflict {
+		t.Errorf("second create: %d, want %d", code, http.StatusConflict)
+	}
+
+	if code := srv.do(t, "DELETE", "/api/replays/1", "", nil); code != http.StatusNoContent {
+		t.Errorf("delete: %d", code)
+	}
+
+	tests := []struct {
+		method, path string
+		code         int
+	}{
+		{"GET", "/api/replays/1", http.StatusNotFound},
+		{"DELETE", "/api/replays/1", http.StatusNotFound},
+		{"GET", "/api/replays/x", http.StatusBadRequest},
+		{"GET", "/api/replays/-1", http.StatusBadRequest},
+		{"GET", "/api/replays/4294967296", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := srv.do(t, tt.method, tt.path, "", nil); code != tt.code {
+			t.Errorf("%s %s: %d, want %d", tt.method, tt.path, code, tt.code)
+		}
+	}
+}
+
+func TestAdminGetHighlight(t *testing.T) {
+	srv := newAdminTestServer(t, adminTestState(), nil)
+
+	tests := []struct {
+		start string
+		code  int
+	}{
+		{start: "500", code: http.StatusOK}, // pending
+		{start: "110", code: http.StatusOK}, // in replay 1
+		{start: "200", code: http.StatusOK}, // next to a nil in replay 2
+		{start: "300", code: http.StatusNotFound},
+		{start: "x", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		var hl domain.Highlight
+		code := srv.do(t, "GET", "/api/highlights/"+tt.start, "", &hl)
+		if code != tt.code {
+			t.Errorf("highlight %s: %d, want %d", tt.start, code, tt.code)
+			continue
+		}
+		if code == http.StatusOK && strconv.FormatUint(hl.StartTime, 10) != tt.start {
+			t.Errorf("highlight %s: got start %d", tt.start, hl.StartTime)
+		}
+	}
+
+	var pending []*domain.Highlight
+	if code := srv.do(t, "GET", "/api/highlights", "", &pending); code != http.StatusOK {
+		t.Fatalf("list: %d", code)
+	}
+	if !reflect.DeepEqual(highlightStarts(pending), []uint64{500, 400}) {
+		t.Errorf("pending = %v, want [500 400]", highlightStarts(pending))
+	}
+}
+
+func TestAdminMoveAndDeleteHighlight(t *testing.T) {
+	srv := newAdminTestServer(t, adminTestState(), nil)
+
+	tests := []struct {
+		name  string
+		start string
+		body  string
+		code  int
+	}{
+		{name: "pending to replay", start: "500", body: `{"replayId":1}`, code: http.StatusNoContent},
+		{name: "replay to replay", start: "200", body: `{"replayId":1}`, code: http.StatusNoContent},
+		{name: "replay to pending", start: "100", body: `{"replayId":null}`, code: http.StatusNoContent},
+		{name: "unknown replay", start: "400", body: `{"replayId":9}`, code: http.StatusNotFound},
+		{name: "unknown highlight", start: "300", body: `{}`, code: http.StatusNotFound},
+		{name: "invalid body", start: "400", body: `{`, code: http.StatusBadRequest},
+		{name: "invalid start", start: "x", body: `{}`, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := srv.do(t, "POST", "/api/highlights/"+tt.start+"/move", tt.body, nil); code != tt.code {
+			t.Errorf("%s: %d, want %d", tt.name, code, tt.code)
+		}
+	}
+
+	rs := srv.store.Get().ReplayState
+	if got := highlightStarts(nonNilHighlights(rs.Replays[1].Highlights)); !reflect.DeepEqual(got, []uint64{110, 200, 500}) {
+		t.Errorf("replay 1 = %v, want [110 200 500] in start order", got)
+	}
+	if got := highlightStarts(rs.PendingHighlights); !reflect.DeepEqual(got, []uint64{400, 100}) {
+		t.Errorf("pending = %v, want [400 100]", got)
+	}
+
+	if code := srv.do(t, "DELETE", "/api/highlights/110", "", nil); code != http.StatusNoContent {
+		t.Errorf("delete: %d", code)
+	}
+	if code := srv.do(t, "DELETE", "/api/highlights/110", "", nil); code != http.StatusNotFound {
+		t.Errorf("second delete: %d, want %d", code, http.StatusNotFound)
+	}
+	if code := srv.do(t, "GET", "/api/highlights/110", "", nil); code != http.StatusNotFound {
+		t.Errorf("get after delete: %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAdminMatches(t *testing.T) {
+	disabled := newAdminTestServer(t, domain.State{}, nil)
+	for _, path := range []string{"/api/matches", "/api/matches/m-1"} {
+		if code := disabled.do(t, "GET", path, "", nil); code != http.StatusNotFound {
+			t.Errorf("%s without an archive: %d, want %d", path, code, http.StatusNotFound)
+		}
+	}
+
+	archive, err := persist.NewMatchArchive(filepath.Join(t.TempDir(), "matches"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Save(persist.ArchivedMatch{MatchID: "m-1", MatchInfo: domain.MatchInfo{Map: "Ascent"}}); err != nil {
+		t.Fatal(err)
+	}
+	srv := newAdminTestServer(t, domain.State{}, archive)
+
+	var list []persist.MatchSummary
+	if code := srv.do(t, "GET", "/api/matches", "", &list); code != http.StatusOK {
+		t.Fatalf("list: %d", code)
+	}
+	if len(list) != 1 || list[0].MatchID != "m-1" || list[0].Map != "Ascent" {
+		t.Errorf("list = %+v", list)
+	}
+
+	var m persist.ArchivedMatch
+	if code := srv.do(t, "GET", "/api/matches/m-1", "", &m); code != http.StatusOK || m.MatchID != "m-1" {
+		t.Errorf("get: %d, %+v", code, m)
+	}
+	for _, id := range []string{"m-2", ".hidden"} {
+		if code := srv.do(t, "GET", "/api/matches/"+id, "", nil); code != http.StatusNotFound {
+			t.Errorf("match %q: %d, want %d", id, code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/obs"
+	"github.com/akayumeru/valreplayserver/internal/render"
+	"github.com/akayumeru/valreplayserver/internal/replays"
+	"github.com/akayumeru/valreplayserver/internal/store"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+)
+
+// ControlHandler lets the caster start and stop replays by hand, e.g. from a
+// Stream Deck. Parameters are read from the query string or a form body so
+// a bare POST works.
+type ControlHandler struct {
+	Store         *store.StateStore
+	Events        *EventsHandler
+	ObsController *obs.Controller
+
+	// for the /control panel
+	Hub      *stream.Hub
+	Renderer *render.Renderer
+}
+
+type controlResponse struct {
+	OK       bool    `json:"ok"`
+	Action   string  `json:"action"`
+	ReplayID *uint32 `json:"replayId,omitempty"`
+	Message  string  `json:"message"`
+}
+
+// StartReplay plays replay_id, or the latest replay of round, or, with
+// neither, builds a replay from the pending highlights like a round change.
+func (h *ControlHandler) StartReplay(w http.ResponseWriter, r *http.Request) {
+	status, resp := h.startReplay(r.Context(), r.FormValue("replay_id"), r.FormValue("round"))
+	writeJSON(w, status, resp)
+}
+
+func (h *ControlHandler) StopReplay(w http.ResponseWriter, r *http.Request) {
+	status, resp := h.stopReplay()
+	writeJSON(w, status, resp)
+}
+
+// SkipReplay cuts the replay on air and resumes the replay buffer, as the
+// start of the next round would.
+func (h *ControlHandler) SkipReplay(w http.ResponseWriter, r *http.Request) {
+	status, resp := h.skipReplay()
+	writeJSON(w, status, resp)
+}
+
+// The actions below are shared with the WebSocket transport and return the
+// HTTP status alongside the response.
+
+func (h *ControlHandler) startReplay(ctx context.Context, replayID, round string) (int, controlResponse) {
+	var id uint32
+	var err error
+
+	switch {
+	case replayID != "":
+		id64, parseErr := strconv.ParseUint(replayID, 10, 32)
+		if parseErr != nil {
+			return http.StatusBadRequest, controlResponse{Action: "start", Message: "invalid replay_id"}
+		}
+		id = uint32(id64)
+		if _, ok := h.Store.Get().ReplayState.Replays[id]; !ok {
+			return http.StatusNotFound, controlResponse{Action: "start", Message: replays.ErrReplayNotFound.Error()}
+		}
+		err = h.ObsController.StartReplay(id)
+
+	case round != "":
+		n, parseErr := strconv.Atoi(round)
+		if parseErr != nil {
+			return http.StatusBadRequest, controlResponse{Action: "start", Message: "invalid round"}
+		}
+		var found bool
+		if id, found = h.latestReplayForRound(n); !found {
+			return http.StatusNotFound, controlResponse{Action: "start", Message: fmt.Sprintf("no replay for round %d", n)}
+		}
+		err = h.ObsController.StartReplay(id)
+
+	default:
+		id, err = h.Events.CreateReplayAndStart(ctx)
+		if errors.Is(err, replays.ErrReplayNotCreated) {
+			return http.StatusConflict, controlResponse{Action: "start", Message: "no pending highlights"}
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return http.StatusGatewayTimeout, controlResponse{Action: "start", Message: "timed out waiting for OBS to save the replay buffer"}
+		}
+	}
+
+	if err != nil {
+		return http.StatusBadGateway, controlResponse{Action: "start", ReplayID: &id, Message: err.Error()}
+	}
+
+	return http.StatusOK, controlResponse{OK: true, Action: "start", ReplayID: &id, Message: fmt.Sprintf("replay %d started", id)}
+}
+
+func (h *ControlHandler) stopReplay() (int, controlResponse) {
+	wasPlaying := h.ObsController.IsPlaying()
+
+	if err := h.ObsController.StopReplay(); err != nil {
+		return http.StatusBadGateway, controlResponse{Action: "stop", Message: err.Error()}
+	}
+
+	msg := "replay stopped"
+	if !wasPlaying {
+		msg = "no replay playing"
+	}
+	return http.StatusOK, controlResponse{OK: true, Action: "stop", Message: msg}
+}
+
+func (h *ControlHandler) skipReplay() (int, controlResponse) {
+	if err := h.ObsController.StopReplay(); err != nil {
+		return http.StatusBadGateway, controlResponse{Action: "skip", Message: err.Error()}
+	}
+	if err := h.ObsController.StartReplayBuffer(); err != nil {
+		return http.StatusBadGateway, controlResponse{Action: "skip", Message: err.Error()}
+	}
+
+	return http.StatusOK, controlResponse{OK: true, Action: "skip", Message: "back to live"}
+}
+
+// Run pushes the control panel whenever the state changes. The panel shows
+// nearly everything, so it follows the store version instead of topics.
+func (h *ControlHandler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if v := h.Store.Version(); v != last {
+				last = v
+				h.Hub.Publish("control", h.Renderer.RenderControlFragment(h.Store.Get()))
+			}
+		}
+	}
+}
+
+func (h *ControlHandler) latestReplayForRound(round int) (uint32, bool) {
+	var id uint32
+	var found bool
+	for rid, replay := range h.Store.Get().ReplayState.Replays {
+		if replay.RoundNumber == round && (!found || rid > id) {
+			id, found = rid, true
+		}
+	}
+	return id, found
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/render"
+	"github.com/akayumeru/valreplayserver/internal/store"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+)
+
+// DataHandler serves the JSON data topics (see render.DataTopics), as a
+// snapshot or an SSE stream. The game events and debug handlers publish
+// them through Publish; the replays topic is published by whatever changes
+// the replays, playback or pending highlights (see PublishReplays).
+type DataHandler struct {
+	Store   *store.StateStore
+	Hub     *stream.Hub
+	Screens *ScreensHandler
+
+	mu      sync.Mutex
+	last    map[string][]byte
+	version map[string]uint64
+}
+
+// dataHubTopic is the hub topic carrying a data topic, kept apart from the
+// HTML screen topics of the same name.
+func dataHubTopic(topic string) string {
+	return "data/" + topic
+}
+
+type dataIndex struct {
+	Schema int      `json:"schema"`
+	Topics []string `json:"topics"`
+}
+
+func (h *DataHandler) Index(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, dataIndex{Schema: render.DataSchemaVersion, Topics: render.DataTopics})
+}
+
+func (h *DataHandler) Get(w http.ResponseWriter, r *http.Request) {
+	b, ok := render.RenderData(r.PathValue("topic"), h.Store.Get())
+	if !ok {
+		http.Error(w, "unknown topic", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// Stream sends the topic as "update" events, each a full DataMessage.
+func (h *DataHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	topic := r.PathValue("topic")
+	if !render.IsDataTopic(topic) {
+		http.Error(w, "unknown topic", http.StatusNotFound)
+		return
+	}
+
+	h.Screens.serveStream(w, r, dataHubTopic(topic), func(st domain.State) []byte {
+		b, _ := render.RenderData(topic, st)
+		return b
+	})
+}
+
+// Publish publishes the data topics among topics whose JSON changed since
+// they were last published. It is called by whoever publishes the matching
+// screens, with the state they rendered; a state older than the one a topic
+// was last published from is ignored for that topic.
+func (h *DataHandler) Publish(st domain.State, topics ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.last == nil {
+		h.last = make(map[string][]byte, len(render.DataTopics))
+		h.version = make(map[string]uint64, len(render.DataTopics))
+	}
+	for _, topic := range topics {
+		if st.Version < h.version[topic] {
+			continue
+		}
+		b, ok := render.RenderData(topic, st)
+		if !ok || bytes.Equal(b, h.last[topic]) {
+			continue
+		}
+		h.last[topic] = b
+		h.version[topic] = st.Version
+		h.Hub.PublishMessage(dataHubTopic(topic), stream.Message{Payload: b, Version: st.Version})
+	}
+}
+
+// PublishReplays publishes the replays topic. It fits the OnChange hooks of
+// the replay builder and highlighter and the OBS controller's OnPlayback.
+func (h *DataHandler) PublishReplays(st domain.State) {
+	h.Publish(st, render.DataReplays)
+}
+
+// gameDataTopics maps the topics of a game event (valorant.Topics.List) to
+// the data topics they change.
+func gameDataTopics(topics []string) []string {
+	// match_info also carries game_info.state, which no topic reports
+	out := []string{render.DataMatchInfo}
+	for _, t := range topics {
+		switch t {
+		case "player_picks":
+			out = append(out, render.DataRoster)
+		case "match_info", "round_outcome", "spike", "player_stats":
+			out = append(out, render.DataRound)
+		case "scoreboard":
+			out = append(out, render.DataScoreboard)
+		case "kill_feed":
+			out = append(out, render.DataKillFeed)
+		}
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/render"
+	"github.com/akayumeru/valreplayserver/internal/replays"
+	"github.com/akayumeru/valreplayserver/internal/store"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+)
+
+func TestDataPublishReplays(t *testing.T) {
+	s := store.NewStateStore(adminTestState())
+	hub := stream.NewHub()
+	hub.BufferSize = 16
+	data := &DataHandler{Store: s, Hub: hub}
+
+	base, _ := url.Parse("http://replays.test")
+	b := &replays.Builder{Store: s, BaseURL: base, OnChange: data.PublishReplays}
+
+	if _, _, err := b.CreateReplay(); err != nil {
+		t.Fatal(err)
+	}
+	created := s.Get()
+	// a failed change publishes nothing
+	if err := b.DeleteReplay(9); err == nil {
+		t.Fatal("deleted an unknown replay")
+	}
+	if err := b.DeleteReplay(1); err != nil {
+		t.Fatal(err)
+	}
+	deleted := s.Get()
+
+	// late publishes of an older state, or of the same JSON, are dropped
+	data.PublishReplays(created)
+	data.PublishReplays(deleted)
+
+	msgs, ok := hub.Since(dataHubTopic(render.DataReplays), 0)
+	if !ok {
+		t.Fatal("replays topic evicted")
+	}
+	want := []struct {
+		version uint64
+		replays int
+	}{
+		{version: created.Version, replays: 3},
+		{version: deleted.Version, replays: 2},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("published %d messages, want %d", len(msgs), len(want))
+	}
+	for i, w := range want {
+		var got struct {
+			Data render.ReplaysData `json:"data"`
+		}
+		if err := json.Unmarshal(msgs[i].Payload, &got); err != nil {
+			t.Fatal(err)
+		}
+		if msgs[i].Version != w.version || len(got.Data.Replays) != w.replays || got.Data.PendingHighlights != 0 {
+			t.Errorf("message %d: version %d, %+v; want version %d with %d replays",
+				i, msgs[i].Version, got.Data, w.version, w.replays)
+		}
+	}
+}
+
+func TestDataPublishOtherTopics(t *testing.T) {
+	hub := stream.NewHub()
+	hub.BufferSize = 16
+	data := &DataHandler{Hub: hub}
+
+	st := domain.State{Version: 4, MatchInfo: domain.MatchInfo{Map: "Ascent"}}
+	data.Publish(st, render.DataMatchInfo, "nope")
+	// the replays topic has its own version
+	data.PublishReplays(domain.State{Version: 2})
+
+	if msgs, _ := hub.Since(dataHubTopic(render.DataMatchInfo), 0); len(msgs) != 1 || msgs[0].Version != 4 {
+		t.Errorf("match_info messages = %+v, want one of version 4", msgs)
+	}
+	if msgs, _ := hub.Since(dataHubTopic(render.DataReplays), 0); len(msgs) != 1 || msgs[0].Version != 2 {
+		t.Errorf("replays messages = %+v, want one of version 2", msgs)
+	}
+	if msgs, _ := hub.Since(dataHubTopic("nope"), 0); len(msgs) != 0 {
+		t.Errorf("unknown topic published: %+v", msgs)
+	}
+}
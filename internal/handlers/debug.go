@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/persist"
+	"github.com/akayumeru/valreplayserver/internal/render"
+	"github.com/akayumeru/valreplayserver/internal/store"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+)
+
+// DebugHandler exposes the state store's change history and hub delivery
+// counters for inspection, and can roll the state back to a recorded change.
+type DebugHandler struct {
+	Store       *store.StateStore
+	Hub         *stream.Hub
+	Snapshotter *persist.Snapshotter
+	Screens     *ScreensHandler
+	Data        *DataHandler
+}
+
+type historyResponse struct {
+	Version uint64         `json:"version"`
+	Changes []store.Change `json:"changes"`
+}
+
+type changeDetail struct {
+	Version uint64       `json:"version"`
+	At      time.Time    `json:"at"`
+	Cause   string       `json:"cause"`
+	State   domain.State `json:"state"`
+}
+
+type restoreResponse struct {
+	Version      uint64 `json:"version"`
+	RestoredFrom uint64 `json:"restored_from"`
+}
+
+// StateHistory lists recorded changes, oldest first. ?since=N skips versions <= N.
+func (h *DebugHandler) StateHistory(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+
+	writeJSON(w, http.StatusOK, historyResponse{
+		Version: h.Store.Version(),
+		Changes: h.Store.History(since),
+	})
+}
+
+// StateAt returns the state as it was right after the given version.
+func (h *DebugHandler) StateAt(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.ParseUint(r.PathValue("version"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+
+	c, ok := h.Store.At(version)
+	if !ok {
+		http.Error(w, "version not in history", http.StatusNotFound)
+		return
+	}
+
+	st := c.State
+	if st.ObsConnectionOptions != nil {
+		st.ObsConnectionOptions.Password = ""
+	}
+
+	writeJSON(w, http.StatusOK, changeDetail{
+		Version: c.Version,
+		At:      c.At,
+		Cause:   c.Cause,
+		State:   st,
+	})
+}
+
+// RestoreState makes the state recorded at the given version current again,
+// to undo a bad game update; replays are left as they are. Screens reload
+// to show it.
+func (h *DebugHandler) RestoreState(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.ParseUint(r.PathValue("version"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+
+	st, ok := h.Store.Restore(version)
+	if !ok {
+		http.Error(w, "version not in history", http.StatusNotFound)
+		return
+	}
+	log.Printf("[Debug] restored state of version %d as version %d", version, st.Version)
+
+	if h.Snapshotter != nil {
+		h.Snapshotter.RequestSave()
+	}
+	if h.Screens != nil {
+		h.Screens.NotifyReload()
+	}
+	if h.Data != nil {
+		h.Data.Publish(st, render.DataTopics...)
+	}
+
+	writeJSON(w, http.StatusOK, restoreResponse{Version: st.Version, RestoredFrom: version})
+}
+
+// HubStats reports per-topic sequence numbers and slow-consumer drops.
+func (h *DebugHandler) HubStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Hub.Stats())
+}
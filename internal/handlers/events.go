@@ -5,7 +5,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/akayumeru/valreplayserver/internal/capture"
 	"github.com/akayumeru/valreplayserver/internal/domain"
 	"github.com/akayumeru/valreplayserver/internal/highlighter"
 	"github.com/akayumeru/valreplayserver/internal/obs"
@@ -25,6 +27,13 @@ type EventsHandler struct {
 	ReplayBuilder *replays.Builder
 	Highligher    *highlighter.Highlighter
 	ObsController *obs.Controller
+
+	// Recorder, when set, captures every raw payload (see cmd/replay-session)
+	Recorder *capture.Recorder
+	// Archive, when set, keeps every finished match
+	Archive *persist.MatchArchive
+	// Data, when set, gets the data topics the event changed
+	Data *DataHandler
 }
 
 func (h *EventsHandler) HandleGameEvent(w http.ResponseWriter, r *http.Request) {
@@ -34,8 +43,18 @@ func (h *EventsHandler) HandleGameEvent(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if h.Recorder != nil {
+		if err := h.Recorder.Record(time.Now(), body); err != nil {
+			log.Printf("[Capture] record failed: %v", err)
+		}
+	}
+
 	var topics []string
-	next := h.Store.Update(func(curState domain.State) domain.State {
+	var newKills int
+	var killFeedReset bool
+	var ended *domain.State
+	var endReason string
+	next := h.Store.Update("game_event", func(curState domain.State) domain.State {
 		cur := curState
 
 		updated, touched, applyErr := valorant.ApplyPayload(cur, body)
@@ -43,7 +62,24 @@ func (h *EventsHandler) HandleGameEvent(w http.ResponseWriter, r *http.Request)
 			return cur
 		}
 
+		// both wipe the match from the state; keep a copy for the archive
+		if h.Archive != nil {
+			switch {
+			case cur.MatchInfo.MatchID == "":
+			case touched.MatchEnded:
+				endReason = persist.ArchiveMatchEnd
+			case cur.MatchInfo.MatchID != "" && updated.MatchInfo.MatchID != cur.MatchInfo.MatchID:
+				endReason = persist.ArchiveMatchChanged
+			}
+			if endReason != "" {
+				c := cur.Clone()
+				ended = &c
+			}
+		}
+
 		topics = touched.List()
+		newKills = touched.NewKillFeedEntries
+		killFeedReset = touched.KillFeedReset
 		return updated
 	})
 
@@ -53,30 +89,101 @@ func (h *EventsHandler) HandleGameEvent(w http.ResponseWriter, r *http.Request)
 			h.Hub.Publish(t, h.Renderer.RenderPlayerPicksFragment(next))
 		case "match_info":
 			h.Hub.Publish(t, h.Renderer.RenderMatchInfoFragment(next))
+		case "scoreboard":
+			h.Hub.Publish(t, h.Renderer.RenderScoreboardFragment(next))
+		case "kill_feed":
+			if killFeedReset {
+				// the whole feed, which includes any entries added after the reset
+				h.Hub.PublishMessage(t, stream.Message{
+					Event:   "update",
+					Payload: h.Renderer.RenderKillFeedFragment(next),
+					Version: next.Version,
+				})
+				break
+			}
+			// entries are increments; tagging them with the version keeps a
+			// stream that just rendered next from showing them twice
+			feed := next.MatchInfo.KillFeed
+			for _, k := range feed[len(feed)-newKills:] {
+				h.Hub.PublishMessage(t, stream.Message{
+					Payload: h.Renderer.RenderKillFeedEntryFragment(k),
+					Version: next.Version,
+				})
+			}
 		case "highlight":
 			h.Highligher.RecordHighlight()
 		case "trigger_replay":
-			h.CreateReplayAndStart()
+			// the replay is due even if the game client hangs up meanwhile
+			h.CreateReplayAndStart(context.WithoutCancel(r.Context()))
 		case "start_replay_buffer":
 			h.ObsController.StopReplay()
 			h.ObsController.StartReplayBuffer()
 		}
 	}
 
+	if h.Data != nil {
+		h.Data.Publish(next, gameDataTopics(topics)...)
+	}
+
+	// after the topics, so the replay built at match end is included
+	if ended != nil {
+		h.archiveMatch(*ended, endReason)
+	}
+
 	h.Snapshotter.RequestSave()
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *EventsHandler) CreateReplayAndStart() {
-	h.Highligher.FlushIfHasHighlightsNow(context.Background())
+func (h *EventsHandler) archiveMatch(ended domain.State, reason string) {
+	id := ended.MatchInfo.MatchID
+	if h.Archive.Has(id) || len(ended.MatchInfo.Rounds) == 0 {
+		// archived at the first match_end, when the rounds were still there;
+		// a repeated match_end must not replace it with an empty match
+		return
+	}
+
+	// replays and highlights live on in the state; take the current ones
+	ended.ReplayState = h.Store.Get().Clone().ReplayState
+
+	m := persist.NewArchivedMatch(ended, reason, time.Now())
+	if err := h.Archive.Save(m); err != nil {
+		log.Printf("[Archive] saving match %s failed: %v", id, err)
+		return
+	}
+	log.Printf("[Archive] match %s archived (%s, %d rounds, %d replays)", id, reason, len(m.MatchInfo.Rounds), len(m.Replays))
+}
+
+// replayFlushTimeout bounds the wait for OBS to save the replay buffer
+// before a replay is built from it.
+const replayFlushTimeout = 15 * time.Second
+
+// CreateReplayAndStart saves the pending highlights, builds a replay from
+// them and plays it. It returns context.DeadlineExceeded when OBS does not
+// save the replay buffer within replayFlushTimeout.
+func (h *EventsHandler) CreateReplayAndStart(ctx context.Context) (uint32, error) {
+	ctx, cancel := context.WithTimeout(ctx, replayFlushTimeout)
+	defer cancel()
+
+	if _, err := h.Highligher.FlushIfHasHighlightsNow(ctx); err != nil {
+		if ctx.Err() != nil {
+			log.Printf("saving the replay buffer: %v", err)
+			return 0, ctx.Err()
+		}
+		log.Printf("saving the replay buffer failed, building the replay anyway: %v", err)
+	}
 
 	replayId, _, err := h.ReplayBuilder.CreateReplay()
 	if err != nil {
 		log.Println(err)
-		return
+		return 0, err
+	}
+
+	if err := h.ObsController.StartReplay(replayId); err != nil {
+		log.Printf("start replay %d failed: %v", replayId, err)
+		return replayId, err
 	}
 
-	h.ObsController.StartReplay(replayId)
+	return replayId, nil
 }
 
 type HighlightRecordRequest struct {
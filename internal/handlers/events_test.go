@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/highlighter"
+	"github.com/akayumeru/valreplayserver/internal/obs"
+	"github.com/akayumeru/valreplayserver/internal/obs/obstest"
+	"github.com/akayumeru/valreplayserver/internal/persist"
+	"github.com/akayumeru/valreplayserver/internal/render"
+	"github.com/akayumeru/valreplayserver/internal/replays"
+	"github.com/akayumeru/valreplayserver/internal/store"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+	"github.com/akayumeru/valreplayserver/web"
+	obsEvents "github.com/andreykaipov/goobs/api/events"
+)
+
+func TestHighlightToReplaySceneSwitch(t *testing.T) {
+	dir := t.TempDir()
+
+	fake := obstest.NewServer(dir)
+	defer fake.Close()
+	fake.SetProgramScene("Game")
+
+	client, err := obs.Dial(fake.Addr(), "")
+	if err != nil {
+		t.Fatalf("dial fake obs: %v", err)
+	}
+	defer client.Disconnect()
+
+	st := store.NewStateStore(domain.State{
+		MatchInfo: domain.MatchInfo{
+			Rounds: make(map[int]*domain.Round),
+			Roster: make(map[string]domain.RosterPlayer),
+		},
+	})
+	snapshotter := persist.NewSnapshotter(filepath.Join(dir, "state.json"), st, time.Hour)
+
+	renderer, err := render.NewRenderer(web.Templates())
+	if err != nil {
+		t.Fatalf("renderer: %v", err)
+	}
+
+	// ffprobe is not needed: the highlighter falls back to the buffer length
+	hl := highlighter.New(filepath.Join(dir, "no-ffprobe"), st, snapshotter, client)
+	defer hl.Close()
+
+	go client.Listen(func(e any) {
+		if ev, ok := e.(*obsEvents.ReplayBufferSaved); ok {
+			hl.OnReplayBufferSaved(ev.SavedReplayPath)
+		}
+	})
+
+	baseURL := &url.URL{Scheme: "http", Host: "127.0.0.1:8080"}
+	events := &EventsHandler{
+		Store:       st,
+		Hub:         stream.NewHub(),
+		Renderer:    renderer,
+		Snapshotter: snapshotter,
+		ReplayBuilder: &replays.Builder{
+			Store:   st,
+			BaseURL: baseURL,
+		},
+		Highligher: hl,
+		ObsController: &obs.Controller{
+			StateStore:      st,
+			ReplaySceneName: "Replay",
+			VlcInputName:    "Replay Source",
+			Obs:             client,
+			BaseURL:         baseURL,
+		},
+	}
+
+	post := func(body string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/events/game_event", strings.NewReader(body))
+		events.HandleGameEvent(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("POST %s: status %d", body, rec.Code)
+		}
+	}
+
+	post(`{"match_info":{"match_id":"m1"}}`)
+	post(`{"match_info":{"round_number":"1"}}`)
+	post(`{"match_info":{"round_phase":"combat"}}`)
+	if !fake.ReplayBufferActive() {
+		t.Fatalf("replay buffer was not started on combat")
+	}
+
+	post(`{"events":[{"name":"kill","data":"1"}]}`)
+	post(`{"match_info":{"round_number":"2"}}`)
+
+	if got := len(fake.SavedReplays()); got != 1 {
+		t.Fatalf("saved replays = %d, want 1", got)
+	}
+
+	state := st.Get()
+	replay, ok := state.ReplayState.Replays[0]
+	if !ok {
+		t.Fatalf("replay 0 was not created: %+v", state.ReplayState)
+	}
+
+	var paths []string
+	for _, h := range replay.Highlights {
+		if h != nil {
+			paths = append(paths, h.MediaPath)
+		}
+	}
+	if len(paths) != 1 || paths[0] != fake.SavedReplays()[0] {
+		t.Fatalf("replay highlights = %v, want %v", paths, fake.SavedReplays())
+	}
+
+	if got := fake.ProgramScene(); got != "Replay" {
+		t.Fatalf("program scene = %q, want Replay", got)
+	}
+	if fake.ReplayBufferActive() {
+		t.Fatalf("replay buffer should be stopped while the replay plays")
+	}
+
+	playlist, _ := fake.InputSettings("Replay Source")["playlist"].([]any)
+	if len(playlist) != 1 {
+		t.Fatalf("playlist = %v", fake.InputSettings("Replay Source"))
+	}
+	item, _ := playlist[0].(map[string]any)
+	if v, _ := item["value"].(string); !strings.Contains(v, "replay_id=0") {
+		t.Fatalf("playlist url = %q", v)
+	}
+
+	if err := events.ObsController.StopReplay(); err != nil {
+		t.Fatalf("stop replay: %v", err)
+	}
+	if got := fake.ProgramScene(); got != "Game" {
+		t.Fatalf("program scene after stop = %q, want Game", got)
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// trustedOrigin reports whether r may change state: it comes from one of
+// our own pages, or from a client that sends no Origin (a Stream Deck
+// plugin, curl) or "null" (a browser source opened from a local file). Any
+// other page could otherwise start replays on air from a viewer's browser.
+func trustedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// SameOrigin rejects requests from other sites' pages. The CORS policy lets
+// any origin through for the game events, and a form POST needs no
+// preflight, so every route that changes state is wrapped in it.
+func SameOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !trustedOrigin(r) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
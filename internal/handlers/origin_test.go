@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	h := SameOrigin(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		origin string
+		code   int
+	}{
+		{origin: "", code: http.StatusNoContent},
+		{origin: "null", code: http.StatusNoContent},
+		{origin: "http://127.0.0.1:8080", code: http.StatusNoContent},
+		{origin: "https://evil.example", code: http.StatusForbidden},
+		{origin: "http://127.0.0.1:8081", code: http.StatusForbidden},
+		{origin: "%zz", code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8080/control/replay/skip", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tt.code {
+			t.Errorf("origin %q: %d, want %d", tt.origin, w.Code, tt.code)
+		}
+	}
+}
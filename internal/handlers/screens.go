@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
+	"github.com/akayumeru/valreplayserver/internal/domain"
 	"github.com/akayumeru/valreplayserver/internal/render"
 	"github.com/akayumeru/valreplayserver/internal/store"
 	"github.com/akayumeru/valreplayserver/internal/stream"
@@ -36,6 +39,46 @@ func (h *ScreensHandler) MatchInfoPage(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(page)
 }
 
+func (h *ScreensHandler) ScoreboardPage(w http.ResponseWriter, r *http.Request) {
+	page, err := h.Renderer.RenderScoreboardPage(h.Store.Get())
+	if err != nil {
+		http.Error(w, "render failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(page)
+}
+
+func (h *ScreensHandler) KillFeedPage(w http.ResponseWriter, r *http.Request) {
+	page, err := h.Renderer.RenderKillFeedPage(h.Store.Get())
+	if err != nil {
+		http.Error(w, "render failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(page)
+}
+
+func (h *ScreensHandler) ObsStatusPage(w http.ResponseWriter, r *http.Request) {
+	page, err := h.Renderer.RenderObsStatusPage(h.Store.Get())
+	if err != nil {
+		http.Error(w, "render failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(page)
+}
+
+func (h *ScreensHandler) ControlPage(w http.ResponseWriter, r *http.Request) {
+	page, err := h.Renderer.RenderControlPage(h.Store.Get())
+	if err != nil {
+		http.Error(w, "render failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(page)
+}
+
 func (h *ScreensHandler) PlayerPicksStream(w http.ResponseWriter, r *http.Request) {
 	h.serveSSE(w, r, "player_picks")
 }
@@ -44,7 +87,49 @@ func (h *ScreensHandler) MatchInfoStream(w http.ResponseWriter, r *http.Request)
 	h.serveSSE(w, r, "match_info")
 }
 
+func (h *ScreensHandler) ScoreboardStream(w http.ResponseWriter, r *http.Request) {
+	h.serveSSE(w, r, "scoreboard")
+}
+
+func (h *ScreensHandler) KillFeedStream(w http.ResponseWriter, r *http.Request) {
+	h.serveSSE(w, r, "kill_feed")
+}
+
+func (h *ScreensHandler) ObsStatusStream(w http.ResponseWriter, r *http.Request) {
+	h.serveSSE(w, r, "obs_status")
+}
+
+func (h *ScreensHandler) ControlStream(w http.ResponseWriter, r *http.Request) {
+	h.serveSSE(w, r, "control")
+}
+
+const reloadTopic = "reload"
+
+// NotifyReload tells every connected screen to reload the page, e.g. after
+// templates changed on disk.
+func (h *ScreensHandler) NotifyReload() {
+	h.Hub.Publish(reloadTopic, []byte(`<script>location.reload()</script>`))
+}
+
+// publishedEvent is the SSE event name for m published on topic; the first
+// message on every stream is always a full "update".
+func publishedEvent(topic string, m stream.Message) string {
+	if m.Event != "" {
+		return m.Event
+	}
+	if topic == "kill_feed" {
+		return "entry"
+	}
+	return "update"
+}
+
 func (h *ScreensHandler) serveSSE(w http.ResponseWriter, r *http.Request, topic string) {
+	h.serveStream(w, r, topic, func(st domain.State) []byte { return h.renderFragment(topic, st) })
+}
+
+// serveStream streams a hub topic as SSE. render produces the full "update"
+// sent first and whenever the client can't be caught up message by message.
+func (h *ScreensHandler) serveStream(w http.ResponseWriter, r *http.Request, topic string, render func(domain.State) []byte) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
@@ -58,15 +143,30 @@ func (h *ScreensHandler) serveSSE(w http.ResponseWriter, r *http.Request, topic
 	ch, cancel := h.Hub.Subscribe(topic)
 	defer cancel()
 
-	var first []byte
-	switch topic {
-	case "player_picks":
-		first = h.Renderer.RenderPlayerPicksFragment(h.Store.Get())
-	case "match_info":
-		first = h.Renderer.RenderMatchInfoFragment(h.Store.Get())
+	reloadCh, cancelReload := h.Hub.Subscribe(reloadTopic)
+	defer cancelReload()
+
+	cur := newTopicCursor(h.Hub, h.Store, topic)
+	send := func(msgs []stream.Message) {
+		for _, m := range msgs {
+			writeSSE(w, h.Hub.EventID(m.Seq), publishedEvent(topic, m), m.Payload)
+		}
+	}
+	resync := func() {
+		seq, st := cur.resync()
+		writeSSE(w, h.Hub.EventID(seq), "update", render(st))
+	}
+
+	resumed := false
+	if seq, ok := h.Hub.ParseEventID(r.Header.Get("Last-Event-ID")); ok {
+		var missed []stream.Message
+		if missed, resumed = cur.resume(seq); resumed {
+			send(missed)
+		}
+	}
+	if !resumed {
+		resync()
 	}
-	fmt.Fprintf(w, "event: update\n")
-	fmt.Fprintf(w, "data: %s\n\n", first)
 	flusher.Flush()
 
 	ticker := time.NewTicker(15 * time.Second)
@@ -81,13 +181,135 @@ func (h *ScreensHandler) serveSSE(w http.ResponseWriter, r *http.Request, topic
 		case <-ticker.C:
 			fmt.Fprintf(w, ": ping\n\n")
 			flusher.Flush()
-		case payload, ok := <-ch:
+		case msg, ok := <-ch:
 			if !ok {
 				return
 			}
-			fmt.Fprintf(w, "event: update\n")
-			fmt.Fprintf(w, "data: %s\n\n", payload)
+			if msgs, ok := cur.next(msg); ok {
+				send(msgs)
+			} else {
+				resync()
+			}
+			flusher.Flush()
+		case msg, ok := <-reloadCh:
+			if !ok {
+				return
+			}
+			writeSSE(w, "", "reload", msg.Payload)
 			flusher.Flush()
 		}
 	}
 }
+
+// topicCursor tracks the newest message a client has on one topic. Messages
+// at or below it are skipped; a jump past it means the hub dropped some on
+// the way, which are refilled from the hub's buffer. Coalesced topics only
+// ever need the newest message.
+type topicCursor struct {
+	hub      *stream.Hub
+	store    *store.StateStore
+	topic    string
+	coalesce bool
+	last     uint64
+
+	// version of the state the client was last resynced to
+	version uint64
+}
+
+func newTopicCursor(hub *stream.Hub, st *store.StateStore, topic string) *topicCursor {
+	return &topicCursor{hub: hub, store: st, topic: topic, coalesce: hub.Policy(topic) == stream.PolicyCoalesce}
+}
+
+// resume returns what was published after seq. false means the client has
+// to resync from the current state.
+func (c *topicCursor) resume(seq uint64) ([]stream.Message, bool) {
+	missed, ok := c.hub.Since(c.topic, seq)
+	if !ok {
+		return nil, false
+	}
+	if c.coalesce && len(missed) > 1 {
+		missed = missed[len(missed)-1:]
+	}
+	c.last = seq
+	return c.take(missed), true
+}
+
+// resync marks the client as having the current state and returns it
+// with its seq, for the caller to render. Publishers update the store
+// before publishing, so the seq is read first: anything published after it
+// is either sent as usual or, when it was rendered from a state the client
+// now has, skipped by its version.
+func (c *topicCursor) resync() (uint64, domain.State) {
+	c.last = c.hub.Seq(c.topic)
+	st := c.store.Get()
+	c.version = st.Version
+	return c.last, st
+}
+
+// next returns what to deliver now that msg arrived, or false when the gap
+// can't be filled and the client has to resync.
+func (c *topicCursor) next(msg stream.Message) ([]stream.Message, bool) {
+	if msg.Seq > c.last+1 && !c.coalesce {
+		missed, ok := c.hub.Since(c.topic, c.last)
+		if !ok {
+			return nil, false
+		}
+		return c.take(append(missed, msg)), true
+	}
+	return c.take([]stream.Message{msg}), true
+}
+
+func (c *topicCursor) take(msgs []stream.Message) []stream.Message {
+	out := msgs[:0:0]
+	for _, m := range msgs {
+		if m.Seq <= c.last {
+			continue
+		}
+		c.last = m.Seq
+		if m.Version != 0 && m.Version <= c.version {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func isScreenTopic(topic string) bool {
+	switch topic {
+	case "player_picks", "match_info", "scoreboard", "kill_feed", "obs_status", "control":
+		return true
+	}
+	return false
+}
+
+func (h *ScreensHandler) renderFragment(topic string, st domain.State) []byte {
+	switch topic {
+	case "player_picks":
+		return h.Renderer.RenderPlayerPicksFragment(st)
+	case "match_info":
+		return h.Renderer.RenderMatchInfoFragment(st)
+	case "scoreboard":
+		return h.Renderer.RenderScoreboardFragment(st)
+	case "kill_feed":
+		return h.Renderer.RenderKillFeedFragment(st)
+	case "obs_status":
+		return h.Renderer.RenderObsStatusFragment(st)
+	case "control":
+		return h.Renderer.RenderControlFragment(st)
+	}
+	return nil
+}
+
+// writeSSE writes one event; multi-line payloads are split into several
+// data lines, which the client joins back with newlines. An empty id leaves
+// the client's last event ID unchanged.
+func writeSSE(w io.Writer, id, event string, payload []byte) {
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\n", event)
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		fmt.Fprintf(w, "data: %s\n", bytes.TrimRight(line, "\r"))
+	}
+	fmt.Fprint(w, "\n")
+}
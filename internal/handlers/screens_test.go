@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/store"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+)
+
+func cursorSeqs(msgs []stream.Message) []uint64 {
+	out := make([]uint64, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, m.Seq)
+	}
+	return out
+}
+
+// publishAll publishes n messages on topic and returns them as a
+// subscriber would have received them.
+func publishAll(t *testing.T, hub *stream.Hub, topic string, n int) []stream.Message {
+	t.Helper()
+
+	msgs := make([]stream.Message, 0, n)
+	for range n {
+		hub.Publish(topic, nil)
+		last, ok := hub.Since(topic, hub.Seq(topic)-1)
+		if !ok || len(last) != 1 {
+			t.Fatalf("published message not buffered")
+		}
+		msgs = append(msgs, last[0])
+	}
+	return msgs
+}
+
+func TestTopicCursorFillsGaps(t *testing.T) {
+	hub := stream.NewHub()
+	st := store.NewStateStore(domain.State{})
+
+	cur := newTopicCursor(hub, st, "t")
+	if seq, _ := cur.resync(); seq != 0 {
+		t.Fatalf("resync on an empty topic = %d", seq)
+	}
+
+	msgs := publishAll(t, hub, "t", 3)
+
+	// 1 and 2 were dropped on the way; 3 arrives first
+	got, ok := cur.next(msgs[2])
+	if !ok || !reflect.DeepEqual(cursorSeqs(got), []uint64{1, 2, 3}) {
+		t.Fatalf("next(3) = %v, %v; want [1 2 3] from the buffer", cursorSeqs(got), ok)
+	}
+	// already delivered while filling the gap
+	if got, ok := cur.next(msgs[1]); !ok || len(got) != 0 {
+		t.Errorf("next(2) = %v, %v; want nothing", cursorSeqs(got), ok)
+	}
+
+	msgs = publishAll(t, hub, "t", 1)
+	if got, ok := cur.next(msgs[0]); !ok || !reflect.DeepEqual(cursorSeqs(got), []uint64{4}) {
+		t.Errorf("next(4) = %v, %v; want [4]", cursorSeqs(got), ok)
+	}
+}
+
+func TestTopicCursorGapEvicted(t *testing.T) {
+	hub := stream.NewHub()
+	hub.BufferSize = 2
+	st := store.NewStateStore(domain.State{})
+
+	cur := newTopicCursor(hub, st, "t")
+	cur.resync()
+
+	msgs := publishAll(t, hub, "t", 4)
+	if _, ok := cur.next(msgs[3]); ok {
+		t.Fatal("next filled a gap whose start was evicted")
+	}
+
+	if seq, _ := cur.resync(); seq != 4 {
+		t.Errorf("resync = %d, want 4", seq)
+	}
+}
+
+func TestTopicCursorCoalesceSkipsGaps(t *testing.T) {
+	hub := stream.NewHub()
+	hub.BufferSize = 0
+	hub.Policies = map[string]stream.Policy{"t": stream.PolicyCoalesce}
+	st := store.NewStateStore(domain.State{})
+
+	cur := newTopicCursor(hub, st, "t")
+	cur.resync()
+
+	hub.Publish("t", nil)
+	hub.Publish("t", nil)
+	// nothing is buffered, but only the newest message matters anyway
+	got, ok := cur.next(stream.Message{Seq: 2})
+	if !ok || !reflect.DeepEqual(cursorSeqs(got), []uint64{2}) {
+		t.Errorf("next(2) = %v, %v; want [2]", cursorSeqs(got), ok)
+	}
+}
+
+// A publisher updates the store before publishing. A stream that resyncs
+// in between renders a state that already has the update and must not send
+// the message for it again.
+func TestTopicCursorSkipsMessagesInResyncedState(t *testing.T) {
+	hub := stream.NewHub()
+	st := store.NewStateStore(domain.State{})
+
+	before := st.Update("kill", func(cur domain.State) domain.State { return cur })
+	hub.PublishMessage("t", stream.Message{Version: before.Version})
+
+	next := st.Update("kill", func(cur domain.State) domain.State { return cur })
+
+	cur := newTopicCursor(hub, st, "t")
+	seq, got := cur.resync()
+	if seq != 1 || got.Version != next.Version {
+		t.Fatalf("resync = %d, version %d; want 1, version %d", seq, got.Version, next.Version)
+	}
+
+	hub.PublishMessage("t", stream.Message{Version: next.Version})
+	hub.Publish("t", nil)
+	later := st.Update("kill", func(cur domain.State) domain.State { return cur })
+	hub.PublishMessage("t", stream.Message{Version: later.Version})
+
+	msgs, _ := hub.Since("t", 1)
+	var sent []uint64
+	for _, m := range msgs {
+		out, ok := cur.next(m)
+		if !ok {
+			t.Fatalf("next(%d) asked for a resync", m.Seq)
+		}
+		sent = append(sent, cursorSeqs(out)...)
+	}
+	// 2 is in the rendered state; 3 carries no version and is always sent
+	if want := []uint64{3, 4}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/render"
+	"github.com/akayumeru/valreplayserver/internal/store"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+)
+
+type StatusHandler struct {
+	Store    *store.StateStore
+	Hub      *stream.Hub
+	Renderer *render.Renderer
+}
+
+type statusResponse struct {
+	Obs          domain.ObsStatus `json:"obs"`
+	StateVersion uint64           `json:"stateVersion"`
+	UpdatedAt    time.Time        `json:"updatedAt"`
+}
+
+func (h *StatusHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	st := h.Store.Get()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(statusResponse{
+		Obs:          st.ObsStatus,
+		StateVersion: h.Store.Version(),
+		UpdatedAt:    st.UpdatedAt,
+	})
+}
+
+// OnObsStatus records an OBS connection state change and pushes it to overlays.
+func (h *StatusHandler) OnObsStatus(status domain.ObsStatus) {
+	next := h.Store.Update("obs_status", func(cur domain.State) domain.State {
+		next := cur
+		next.ObsStatus = status
+		return next
+	})
+
+	h.Hub.Publish("obs_status", h.Renderer.RenderObsStatusFragment(next))
+}
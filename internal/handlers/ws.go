@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/render"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+	"github.com/gorilla/websocket"
+)
+
+// WSHandler serves /ws: any number of screen topics multiplexed over one
+// socket, plus the replay controls, for tools that prefer WebSockets or need
+// to talk back (custom browser sources, a Stream Deck plugin).
+//
+// Client messages:
+//
+//	{"type":"subscribe","topics":["match_info","kill_feed"]}
+//	{"type":"subscribe","format":"json","topics":["round","replays"]}
+//	{"type":"unsubscribe","topics":["kill_feed"]}
+//	{"type":"command","id":"1","command":"start_replay","replayId":3}
+//
+// format "html" (the default) carries screen fragments in "html"; "json"
+// carries the render.DataTopics in "data", one render.DataMessage each.
+// Commands are start_replay (optional replayId or round), stop_replay and
+// skip_replay, with the same results as POST /control/replay/*. Pages on
+// other sites may subscribe but not send commands.
+type WSHandler struct {
+	Hub     *stream.Hub
+	Screens *ScreensHandler
+	Control *ControlHandler
+}
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 2 * wsPingInterval
+	wsReadLimit    = 64 << 10
+)
+
+var wsUpgrader = websocket.Upgrader{
+	// overlays may be hosted anywhere, so any page may subscribe; commands
+	// are checked per connection, see trustedOrigin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+type wsClientMessage struct {
+	Type     string   `json:"type"`
+	ID       string   `json:"id,omitempty"`
+	Topics   []string `json:"topics,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	Command  string   `json:"command,omitempty"`
+	ReplayID *uint32  `json:"replayId,omitempty"`
+	Round    *int     `json:"round,omitempty"`
+}
+
+type wsServerMessage struct {
+	Type string `json:"type"` // message, subscribed, unsubscribed, result, error
+	ID   string `json:"id,omitempty"`
+
+	Topic  string          `json:"topic,omitempty"`
+	Format string          `json:"format,omitempty"`
+	Seq    uint64          `json:"seq,omitempty"`
+	Event  string          `json:"event,omitempty"` // same as the SSE event name
+	HTML   string          `json:"html,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+
+	Topics []string `json:"topics,omitempty"`
+
+	Status int              `json:"status,omitempty"`
+	Result *controlResponse `json:"result,omitempty"`
+
+	Message string `json:"message,omitempty"`
+}
+
+type wsConn struct {
+	h    *WSHandler
+	conn *websocket.Conn
+	out  chan wsServerMessage
+	ctx  context.Context
+
+	// commands are refused from other sites' pages
+	commands bool
+
+	mu   sync.Mutex
+	subs map[string]*wsSub
+}
+
+type wsSub struct {
+	topic  string
+	format string
+	render func(domain.State) []byte
+	cancel func()
+}
+
+const (
+	wsFormatHTML = "html"
+	wsFormatJSON = "json"
+)
+
+// newSub resolves topic in format to its hub topic and full-state renderer.
+func (c *wsConn) newSub(topic, format string) (hubTopic string, sub *wsSub, ok bool) {
+	switch format {
+	case "", wsFormatHTML:
+		if !isScreenTopic(topic) {
+			return "", nil, false
+		}
+		return topic, &wsSub{topic: topic, format: wsFormatHTML, render: func(st domain.State) []byte {
+			return c.h.Screens.renderFragment(topic, st)
+		}}, true
+	case wsFormatJSON:
+		if !render.IsDataTopic(topic) {
+			return "", nil, false
+		}
+		return dataHubTopic(topic), &wsSub{topic: topic, format: wsFormatJSON, render: func(st domain.State) []byte {
+			b, _ := render.RenderData(topic, st)
+			return b
+		}}, true
+	}
+	return "", nil, false
+}
+
+// message wraps a payload of sub's topic for the client.
+func (sub *wsSub) message(seq uint64, event string, payload []byte) wsServerMessage {
+	msg := wsServerMessage{Type: "message", Topic: sub.topic, Format: sub.format, Seq: seq, Event: event}
+	if sub.format == wsFormatJSON {
+		msg.Data = payload
+	} else {
+		msg.HTML = string(payload)
+	}
+	return msg
+}
+
+func (h *WSHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	c := &wsConn{
+		h:        h,
+		conn:     conn,
+		out:      make(chan wsServerMessage, 64),
+		ctx:      ctx,
+		commands: trustedOrigin(r),
+		subs:     make(map[string]*wsSub),
+	}
+	defer c.unsubscribeAll()
+
+	go func() {
+		// closing the socket unblocks readLoop
+		defer conn.Close()
+		defer cancel()
+		c.writeLoop()
+	}()
+
+	c.readLoop()
+}
+
+func (c *wsConn) readLoop() {
+	c.conn.SetReadLimit(wsReadLimit)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if c.ctx.Err() == nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure) {
+				log.Printf("[WS] read: %v", err)
+			}
+			return
+		}
+
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.send(wsServerMessage{Type: "error", Message: "invalid message: " + err.Error()})
+			continue
+		}
+
+		switch msg.Type {
+		case "subscribe":
+			c.subscribe(msg)
+		case "unsubscribe":
+			for _, topic := range msg.Topics {
+				c.unsubscribe(topic, msg.Format)
+			}
+			c.send(wsServerMessage{Type: "unsubscribed", ID: msg.ID, Format: msg.Format, Topics: msg.Topics})
+		case "command":
+			if !c.commands {
+				c.send(wsServerMessage{Type: "error", ID: msg.ID, Message: "commands are not accepted from this origin"})
+				continue
+			}
+			// commands talk to OBS; keep reading meanwhile
+			go c.command(msg)
+		default:
+			c.send(wsServerMessage{Type: "error", ID: msg.ID, Message: "unknown message type " + strconv.Quote(msg.Type)})
+		}
+	}
+}
+
+func (c *wsConn) writeLoop() {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			_ = c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteTimeout))
+			return
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case msg := <-c.out:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// send queues msg for the writer; it gives up once the connection is gone.
+func (c *wsConn) send(msg wsServerMessage) bool {
+	select {
+	case c.out <- msg:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+func (c *wsConn) subscribe(req wsClientMessage) {
+	var added []string
+	for _, topic := range req.Topics {
+		hubTopic, sub, ok := c.newSub(topic, req.Format)
+		if !ok {
+			c.send(wsServerMessage{Type: "error", ID: req.ID, Topic: topic, Format: req.Format, Message: "unknown topic or format"})
+			continue
+		}
+
+		c.mu.Lock()
+		_, dup := c.subs[hubTopic]
+		c.mu.Unlock()
+		if dup {
+			added = append(added, topic)
+			continue
+		}
+
+		// subscribe before rendering, as serveSSE does, so nothing published
+		// in between is lost
+		ch, cancel := c.h.Hub.Subscribe(hubTopic)
+		sub.cancel = cancel
+		cur := newTopicCursor(c.h.Hub, c.h.Screens.Store, hubTopic)
+		seq, st := cur.resync()
+		first := sub.render(st)
+
+		c.mu.Lock()
+		c.subs[hubTopic] = sub
+		c.mu.Unlock()
+		added = append(added, topic)
+
+		c.send(sub.message(seq, "update", first))
+		go c.forward(hubTopic, sub, ch, cur)
+	}
+
+	c.send(wsServerMessage{Type: "subscribed", ID: req.ID, Format: req.Format, Topics: added})
+}
+
+// forward relays one topic until it is unsubscribed or the hub disconnects
+// the subscriber for falling behind.
+func (c *wsConn) forward(hubTopic string, sub *wsSub, ch chan stream.Message, cur *topicCursor) {
+	for msg := range ch {
+		msgs, ok := cur.next(msg)
+		if !ok {
+			seq, st := cur.resync()
+			msgs = []stream.Message{{Seq: seq, Payload: sub.render(st), Event: "update"}}
+		}
+		for _, m := range msgs {
+			if !c.send(sub.message(m.Seq, publishedEvent(hubTopic, m), m.Payload)) {
+				return
+			}
+		}
+	}
+
+	c.mu.Lock()
+	// the topic may have been unsubscribed and subscribed again meanwhile
+	subscribed := c.subs[hubTopic] == sub
+	if subscribed {
+		delete(c.subs, hubTopic)
+	}
+	c.mu.Unlock()
+	if subscribed && c.ctx.Err() == nil {
+		// closed by the hub, not by unsubscribe
+		c.send(wsServerMessage{Type: "unsubscribed", Format: sub.format, Topics: []string{sub.topic}, Message: "too slow, resubscribe"})
+	}
+}
+
+func (c *wsConn) unsubscribe(topic, format string) {
+	hubTopic, _, ok := c.newSub(topic, format)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	sub, ok := c.subs[hubTopic]
+	delete(c.subs, hubTopic)
+	c.mu.Unlock()
+
+	if ok {
+		sub.cancel()
+	}
+}
+
+func (c *wsConn) unsubscribeAll() {
+	c.mu.Lock()
+	subs := c.subs
+	c.subs = make(map[string]*wsSub)
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.cancel()
+	}
+}
+
+func (c *wsConn) command(req wsClientMessage) {
+	var status int
+	var resp controlResponse
+
+	switch req.Command {
+	case "start_replay":
+		var replayID, round string
+		if req.ReplayID != nil {
+			replayID = strconv.FormatUint(uint64(*req.ReplayID), 10)
+		}
+		if req.Round != nil {
+			round = strconv.Itoa(*req.Round)
+		}
+		status, resp = c.h.Control.startReplay(c.ctx, replayID, round)
+	case "stop_replay":
+		status, resp = c.h.Control.stopReplay()
+	case "skip_replay":
+		status, resp = c.h.Control.skipReplay()
+	default:
+		c.send(wsServerMessage{Type: "error", ID: req.ID, Message: "unknown command " + strconv.Quote(req.Command)})
+		return
+	}
+
+	c.send(wsServerMessage{Type: "result", ID: req.ID, Status: status, Result: &resp})
+}
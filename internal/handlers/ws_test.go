@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/render"
+	"github.com/akayumeru/valreplayserver/internal/store"
+	"github.com/akayumeru/valreplayserver/internal/stream"
+	"github.com/akayumeru/valreplayserver/web"
+	"github.com/gorilla/websocket"
+)
+
+type wsTestServer struct {
+	*httptest.Server
+	hub *stream.Hub
+}
+
+func newWSTestServer(t *testing.T) *wsTestServer {
+	t.Helper()
+
+	renderer, err := render.NewRenderer(web.Templates())
+	if err != nil {
+		t.Fatalf("renderer: %v", err)
+	}
+	hub := stream.NewHub()
+	st := store.NewStateStore(domain.State{
+		MatchInfo: domain.MatchInfo{MatchID: "m-1", Map: "Ascent"},
+	})
+
+	screens := &ScreensHandler{Store: st, Hub: hub, Renderer: renderer}
+	h := &WSHandler{
+		Hub:     hub,
+		Screens: screens,
+		Control: &ControlHandler{Store: st, Hub: hub, Renderer: renderer},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(h.Serve))
+	t.Cleanup(srv.Close)
+	return &wsTestServer{Server: srv, hub: hub}
+}
+
+func (s *wsTestServer) dial(t *testing.T, origin string) *websocket.Conn {
+	t.Helper()
+
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func wsSend(t *testing.T, conn *websocket.Conn, msg string) {
+	t.Helper()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func wsRead(t *testing.T, conn *websocket.Conn) wsServerMessage {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsServerMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return msg
+}
+
+func TestWSSubscribeHTML(t *testing.T) {
+	srv := newWSTestServer(t)
+	conn := srv.dial(t, "")
+
+	wsSend(t, conn, `{"type":"subscribe","id":"1","topics":["match_info","kill_feed","nope"]}`)
+
+	first := wsRead(t, conn)
+	if first.Type != "message" || first.Topic != "match_info" || first.Event != "update" || first.Format != wsFormatHTML {
+		t.Fatalf("first message = %+v, want a match_info update", first)
+	}
+	if !strings.Contains(first.HTML, "Ascent") {
+		t.Errorf("match_info fragment does not show the map: %s", first.HTML)
+	}
+	if msg := wsRead(t, conn); msg.Topic != "kill_feed" || msg.Event != "update" {
+		t.Fatalf("second message = %+v, want a kill_feed update", msg)
+	}
+	if msg := wsRead(t, conn); msg.Type != "error" || msg.Topic != "nope" || msg.ID != "1" {
+		t.Fatalf("third message = %+v, want an error for the unknown topic", msg)
+	}
+	msg := wsRead(t, conn)
+	if msg.Type != "subscribed" || strings.Join(msg.Topics, ",") != "match_info,kill_feed" {
+		t.Fatalf("fourth message = %+v, want subscribed to match_info and kill_feed", msg)
+	}
+
+	srv.hub.Publish("kill_feed", []byte("<li>kill</li>"))
+	if msg := wsRead(t, conn); msg.Topic != "kill_feed" || msg.Event != "entry" || msg.HTML != "<li>kill</li>" || msg.Seq != 1 {
+		t.Errorf("published entry = %+v", msg)
+	}
+
+	wsSend(t, conn, `{"type":"unsubscribe","topics":["kill_feed"]}`)
+	if msg := wsRead(t, conn); msg.Type != "unsubscribed" {
+		t.Fatalf("got %+v, want unsubscribed", msg)
+	}
+	srv.hub.Publish("kill_feed", []byte("<li>late</li>"))
+	srv.hub.Publish("match_info", []byte("<div>next</div>"))
+	// only the topic still subscribed comes through
+	if msg := wsRead(t, conn); msg.Topic != "match_info" || msg.HTML != "<div>next</div>" {
+		t.Errorf("after unsubscribe got %+v", msg)
+	}
+}
+
+func TestWSSubscribeJSON(t *testing.T) {
+	srv := newWSTestServer(t)
+	conn := srv.dial(t, "")
+
+	wsSend(t, conn, `{"type":"subscribe","format":"json","topics":["match_info","player_picks"]}`)
+
+	msg := wsRead(t, conn)
+	if msg.Type != "message" || msg.Format != wsFormatJSON || msg.Topic != "match_info" {
+		t.Fatalf("first message = %+v", msg)
+	}
+	var data render.DataMessage
+	if err := json.Unmarshal(msg.Data, &data); err != nil {
+		t.Fatalf("data: %v", err)
+	}
+	if data.Schema != render.DataSchemaVersion || data.Topic != "match_info" {
+		t.Errorf("data envelope = %+v", data)
+	}
+	if mi, _ := data.Data.(map[string]any); mi["map"] != "Ascent" {
+		t.Errorf("data = %v, want the map", data.Data)
+	}
+
+	// a screen topic, but not a data topic
+	if msg := wsRead(t, conn); msg.Type != "error" || msg.Topic != "player_picks" {
+		t.Errorf("got %+v, want an error for player_picks", msg)
+	}
+}
+
+func TestWSCommandOrigin(t *testing.T) {
+	srv := newWSTestServer(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{origin: "", allowed: true},
+		{origin: "null", allowed: true},
+		{origin: "http://" + host, allowed: true},
+		{origin: "https://evil.example", allowed: false},
+		{origin: "http://" + host + ".evil.example", allowed: false},
+	}
+	for _, tt := range tests {
+		conn := srv.dial(t, tt.origin)
+
+		// an unknown command gets past the origin check without touching OBS
+		wsSend(t, conn, `{"type":"command","id":"c","command":"nope"}`)
+		msg := wsRead(t, conn)
+		if msg.Type != "error" || msg.ID != "c" {
+			t.Fatalf("origin %q: got %+v, want an error", tt.origin, msg)
+		}
+		if allowed := strings.HasPrefix(msg.Message, "unknown command"); allowed != tt.allowed {
+			t.Errorf("origin %q: command allowed = %v, want %v (%s)", tt.origin, allowed, tt.allowed, msg.Message)
+		}
+	}
+}
+
+func TestWSInvalidMessage(t *testing.T) {
+	srv := newWSTestServer(t)
+	conn := srv.dial(t, "")
+
+	wsSend(t, conn, `not json`)
+	if msg := wsRead(t, conn); msg.Type != "error" || !strings.HasPrefix(msg.Message, "invalid message") {
+		t.Errorf("got %+v", msg)
+	}
+	wsSend(t, conn, `{"type":"shout","id":"x"}`)
+	if msg := wsRead(t, conn); msg.Type != "error" || msg.ID != "x" {
+		t.Errorf("got %+v", msg)
+	}
+}
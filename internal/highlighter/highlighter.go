@@ -12,9 +12,9 @@ import (
 	"time"
 
 	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/obs"
 	"github.com/akayumeru/valreplayserver/internal/persist"
 	"github.com/akayumeru/valreplayserver/internal/store"
-	"github.com/andreykaipov/goobs"
 )
 
 type Highlight = domain.Highlight
@@ -51,7 +51,11 @@ type Highlighter struct {
 	FFprobeBin  string
 	Store       *store.StateStore
 	Snapshotter *persist.Snapshotter
-	Obs         *goobs.Client
+	Obs         obs.Client
+
+	// OnChange, when set, is called with the new state after a highlight is
+	// added.
+	OnChange func(st domain.State)
 
 	cfg Config
 
@@ -67,7 +71,7 @@ type Highlighter struct {
 	stopCh chan struct{}
 }
 
-func New(FFprobeBin string, store *store.StateStore, snapshotter *persist.Snapshotter, obsClient *goobs.Client) *Highlighter {
+func New(FFprobeBin string, store *store.StateStore, snapshotter *persist.Snapshotter, obsClient obs.Client) *Highlighter {
 	hl := &Highlighter{
 		FFprobeBin:  FFprobeBin,
 		Store:       store,
@@ -86,7 +90,7 @@ func New(FFprobeBin string, store *store.StateStore, snapshotter *persist.Snapsh
 	return hl
 }
 
-func (hl *Highlighter) getObs() *goobs.Client {
+func (hl *Highlighter) getObs() obs.Client {
 	return hl.Obs
 }
 
@@ -163,8 +167,8 @@ func (hl *Highlighter) SaveReplayBufferForSessionAndWait(ctx context.Context, se
 }
 
 func (hl *Highlighter) requestSave(sessionID uint64, makeWaitCh func() chan error) (chan error, error) {
-	obs := hl.getObs()
-	if obs == nil {
+	obsClient := hl.getObs()
+	if obsClient == nil {
 		return nil, errors.New("obs client is nil")
 	}
 
@@ -209,31 +213,40 @@ func (hl *Highlighter) requestSave(sessionID uint64, makeWaitCh func() chan erro
 	events := append([]time.Time(nil), s.events...)
 	hl.mu.Unlock()
 
-	status, err := obs.Outputs.GetReplayBufferStatus()
-	if err == nil && !status.OutputActive {
-		if _, err2 := obs.Outputs.StartReplayBuffer(); err2 != nil {
+	active, err := obsClient.ReplayBufferActive()
+	if err == nil && !active {
+		if err2 := obsClient.StartReplayBuffer(); err2 != nil {
 			hl.failSave(sessionID, ch, err2)
 			return ch, err2
 		}
 	}
 
-	requestedAt := time.Now()
-	if _, err := obs.Outputs.SaveReplayBuffer(); err != nil {
-		hl.failSave(sessionID, ch, err)
-		return ch, err
-	}
-
 	ps := pendingSave{
 		sessionID:   sessionID,
-		requestedAt: requestedAt,
+		requestedAt: time.Now(),
 		events:      events,
 		waitCh:      ch,
 	}
 
+	// registered before the request: ReplayBufferSaved may arrive before SaveReplayBuffer returns
 	hl.pendingMu.Lock()
 	hl.pending = append(hl.pending, ps)
 	hl.pendingMu.Unlock()
 
+	if err := obsClient.SaveReplayBuffer(); err != nil {
+		hl.pendingMu.Lock()
+		for i := range hl.pending {
+			if hl.pending[i].sessionID == sessionID {
+				hl.pending = append(hl.pending[:i], hl.pending[i+1:]...)
+				break
+			}
+		}
+		hl.pendingMu.Unlock()
+
+		hl.failSave(sessionID, ch, err)
+		return ch, err
+	}
+
 	log.Printf("[Highlighter] SaveReplayBuffer requested session=%d", sessionID)
 	return ch, nil
 }
@@ -405,11 +418,14 @@ func (hl *Highlighter) OnReplayBufferSaved(savedReplayPath string) {
 		}
 	}
 
-	hl.Store.Update(func(cur domain.State) domain.State {
+	next := hl.Store.Update("highlight_added", func(cur domain.State) domain.State {
 		next := cur
 		next.ReplayState.PendingHighlights = append(cur.ReplayState.PendingHighlights, &h)
 		return next
 	})
+	if hl.OnChange != nil {
+		hl.OnChange(next)
+	}
 
 	hl.Snapshotter.RequestSave()
 
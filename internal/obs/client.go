@@ -0,0 +1,104 @@
+package obs
+
+import (
+	"github.com/andreykaipov/goobs"
+	"github.com/andreykaipov/goobs/api/requests/inputs"
+	"github.com/andreykaipov/goobs/api/requests/scenes"
+)
+
+// Client is the part of the obs-websocket API the server relies on.
+type Client interface {
+	ReplayBufferActive() (bool, error)
+	StartReplayBuffer() error
+	StopReplayBuffer() error
+	SaveReplayBuffer() error
+
+	CurrentProgramScene() (string, error)
+	SetCurrentProgramScene(sceneName string) error
+
+	InputSettings(inputName string) (map[string]any, error)
+	SetInputSettings(inputName string, settings map[string]any, overlay bool) error
+
+	// Listen blocks and calls f for every event (goobs event types) until
+	// the connection is closed.
+	Listen(f func(event any))
+	Disconnect() error
+}
+
+type goobsClient struct {
+	c *goobs.Client
+}
+
+func Dial(address string, password string) (Client, error) {
+	c, err := goobs.New(address, goobs.WithPassword(password))
+	if err != nil {
+		return nil, err
+	}
+	return &goobsClient{c: c}, nil
+}
+
+func (g *goobsClient) ReplayBufferActive() (bool, error) {
+	status, err := g.c.Outputs.GetReplayBufferStatus()
+	if err != nil {
+		return false, err
+	}
+	return status.OutputActive, nil
+}
+
+func (g *goobsClient) StartReplayBuffer() error {
+	_, err := g.c.Outputs.StartReplayBuffer()
+	return err
+}
+
+func (g *goobsClient) StopReplayBuffer() error {
+	_, err := g.c.Outputs.StopReplayBuffer()
+	return err
+}
+
+func (g *goobsClient) SaveReplayBuffer() error {
+	_, err := g.c.Outputs.SaveReplayBuffer()
+	return err
+}
+
+func (g *goobsClient) CurrentProgramScene() (string, error) {
+	cur, err := g.c.Scenes.GetCurrentProgramScene(&scenes.GetCurrentProgramSceneParams{})
+	if err != nil {
+		return "", err
+	}
+	return cur.SceneName, nil
+}
+
+func (g *goobsClient) SetCurrentProgramScene(sceneName string) error {
+	_, err := g.c.Scenes.SetCurrentProgramScene(
+		scenes.NewSetCurrentProgramSceneParams().WithSceneName(sceneName),
+	)
+	return err
+}
+
+func (g *goobsClient) InputSettings(inputName string) (map[string]any, error) {
+	resp, err := g.c.Inputs.GetInputSettings(
+		inputs.NewGetInputSettingsParams().WithInputName(inputName),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return resp.InputSettings, nil
+}
+
+func (g *goobsClient) SetInputSettings(inputName string, settings map[string]any, overlay bool) error {
+	_, err := g.c.Inputs.SetInputSettings(
+		inputs.NewSetInputSettingsParams().
+			WithInputName(inputName).
+			WithOverlay(overlay).
+			WithInputSettings(settings),
+	)
+	return err
+}
+
+func (g *goobsClient) Listen(f func(event any)) {
+	g.c.Listen(f)
+}
+
+func (g *goobsClient) Disconnect() error {
+	return g.c.Disconnect()
+}
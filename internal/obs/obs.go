@@ -5,14 +5,12 @@ import (
 	"fmt"
 	"math"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/akayumeru/valreplayserver/internal/domain"
 	"github.com/akayumeru/valreplayserver/internal/store"
 	"github.com/akayumeru/valreplayserver/internal/valorant"
-	"github.com/andreykaipov/goobs"
-	"github.com/andreykaipov/goobs/api/requests/inputs"
-	"github.com/andreykaipov/goobs/api/requests/scenes"
 )
 
 type currentReplay struct {
@@ -24,10 +22,18 @@ type Controller struct {
 	StateStore      *store.StateStore
 	ReplaySceneName string
 	VlcInputName    string
-	Obs             *goobs.Client
+	Obs             Client
 	BaseURL         *url.URL
 
+	// OnPlayback, when set, is called with the new state after the playback
+	// state changes. It runs with the controller locked.
+	OnPlayback func(st domain.State)
+
+	// guards isPlaying/previousScene: replays are started and stopped from
+	// game events, the stream timer and the control endpoints
+	mu            sync.Mutex
 	isPlaying     bool
+	playingID     uint32
 	previousScene string
 
 	current currentReplay
@@ -38,6 +44,10 @@ func (c *Controller) StartReplay(replayID uint32) error {
 		return errors.New("obs client is nil")
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.syncPlayback()
+
 	if c.ReplaySceneName == "" {
 		c.ReplaySceneName = "Replay"
 	}
@@ -54,31 +64,25 @@ func (c *Controller) StartReplay(replayID uint32) error {
 		{"value": replayURL},
 	}
 
-	_, err := c.Obs.Inputs.SetInputSettings(
-		inputs.NewSetInputSettingsParams().
-			WithInputName(c.VlcInputName).
-			WithOverlay(true).
-			WithInputSettings(map[string]any{
-				"playlist": playlist,
-			}),
-	)
+	err := c.Obs.SetInputSettings(c.VlcInputName, map[string]any{
+		"playlist": playlist,
+	}, true)
 	if err != nil {
 		return fmt.Errorf("SetInputSettings(%s): %w", c.VlcInputName, err)
 	}
 
-	cur, err := c.Obs.Scenes.GetCurrentProgramScene(&scenes.GetCurrentProgramSceneParams{})
+	curScene, err := c.Obs.CurrentProgramScene()
 	if err != nil {
 		return fmt.Errorf("GetCurrentProgramScene: %w", err)
 	}
 
-	c.previousScene = cur.SceneName
+	c.previousScene = curScene
 	c.isPlaying = true
+	c.playingID = replayID
 
-	c.Obs.Outputs.StopReplayBuffer()
+	c.Obs.StopReplayBuffer()
 
-	_, err = c.Obs.Scenes.SetCurrentProgramScene(
-		scenes.NewSetCurrentProgramSceneParams().WithSceneName(c.ReplaySceneName),
-	)
+	err = c.Obs.SetCurrentProgramScene(c.ReplaySceneName)
 	if err != nil {
 		c.isPlaying = false
 		return fmt.Errorf("SetCurrentProgramScene(%s): %w", c.ReplaySceneName, err)
@@ -101,20 +105,30 @@ func (c *Controller) SetCurrentReplay(replayID uint32, duration time.Duration) {
 }
 
 func (c *Controller) StartReplayBuffer() error {
-	status, err := c.Obs.Outputs.GetReplayBufferStatus()
+	active, err := c.Obs.ReplayBufferActive()
 
-	if err == nil && !status.OutputActive {
-		_, err = c.Obs.Outputs.StartReplayBuffer()
-		return err
+	if err == nil && !active {
+		return c.Obs.StartReplayBuffer()
 	}
 
 	return err
 }
 
+func (c *Controller) IsPlaying() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isPlaying
+}
+
 func (c *Controller) StopReplay() error {
 	if c.Obs == nil {
 		return errors.New("obs client is nil")
 	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.syncPlayback()
+
 	if !c.isPlaying {
 		return nil
 	}
@@ -123,18 +137,16 @@ func (c *Controller) StopReplay() error {
 		return nil
 	}
 
-	cur, err := c.Obs.Scenes.GetCurrentProgramScene(&scenes.GetCurrentProgramSceneParams{})
+	curScene, err := c.Obs.CurrentProgramScene()
 	if err != nil {
 		return fmt.Errorf("GetCurrentProgramScene: %w", err)
 	}
-	if cur.SceneName != c.ReplaySceneName {
+	if curScene != c.ReplaySceneName {
 		c.isPlaying = false
 		return nil
 	}
 
-	_, err = c.Obs.Scenes.SetCurrentProgramScene(
-		scenes.NewSetCurrentProgramSceneParams().WithSceneName(c.previousScene),
-	)
+	err = c.Obs.SetCurrentProgramScene(c.previousScene)
 	if err != nil {
 		return fmt.Errorf("SetCurrentProgramScene(%s): %w", c.previousScene, err)
 	}
@@ -145,6 +157,32 @@ func (c *Controller) StopReplay() error {
 	return nil
 }
 
+// syncPlayback mirrors isPlaying into the state for the control panel.
+// Called with mu held.
+func (c *Controller) syncPlayback() {
+	if c.StateStore == nil {
+		return
+	}
+
+	cur := c.StateStore.Get().Playback
+	if cur.Playing == c.isPlaying && (!c.isPlaying || cur.ReplayID == c.playingID) {
+		return
+	}
+
+	next := c.StateStore.Update("obs_playback", func(st domain.State) domain.State {
+		next := st
+		next.Playback = domain.Playback{Playing: c.isPlaying}
+		if c.isPlaying {
+			next.Playback.ReplayID = c.playingID
+			next.Playback.StartedAt = time.Now().UTC()
+		}
+		return next
+	})
+	if c.OnPlayback != nil {
+		c.OnPlayback(next)
+	}
+}
+
 func (c *Controller) buildReplayURL(replayID uint32) string {
 	u := *c.BaseURL
 	u.Path = "/replay.ts"
@@ -0,0 +1,335 @@
+// Package obstest provides an in-process obs-websocket v5 server for tests.
+package obstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	opHello           = 0
+	opIdentify        = 1
+	opIdentified      = 2
+	opEvent           = 5
+	opRequest         = 6
+	opRequestResponse = 7
+
+	statusSuccess            = 100
+	statusMissingRequestData = 300
+	statusOutputNotRunning   = 501
+	statusUnknownRequestType = 204
+
+	intentOutputs = 1 << 6
+)
+
+type message struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+type request struct {
+	Type string          `json:"requestType"`
+	ID   string          `json:"requestId"`
+	Data json.RawMessage `json:"requestData"`
+}
+
+type conn struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+}
+
+func (c *conn) send(op int, d any) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteJSON(message{Op: op, D: b})
+}
+
+// Server fakes the subset of obs-websocket the replay server uses. Saving
+// the replay buffer writes a small file into Dir and emits ReplayBufferSaved.
+// Authentication is not checked.
+type Server struct {
+	Dir string
+
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu                 sync.Mutex
+	conns              map[*conn]struct{}
+	replayBufferActive bool
+	programScene       string
+	inputSettings      map[string]map[string]any
+	saved              []string
+	requests           []string
+}
+
+func NewServer(dir string) *Server {
+	s := &Server{
+		Dir:           dir,
+		conns:         make(map[*conn]struct{}),
+		programScene:  "Scene",
+		inputSettings: make(map[string]map[string]any),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Addr is the host:port to pass to obs.Dial.
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.srv.URL, "http://")
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.ws.Close()
+	}
+	s.mu.Unlock()
+	s.srv.Close()
+}
+
+// CloseConnections drops all clients while keeping the server up, like an
+// OBS restart: the replay buffer is stopped as well.
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replayBufferActive = false
+	for c := range s.conns {
+		c.writeMu.Lock()
+		_ = c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "restart"))
+		c.writeMu.Unlock()
+		_ = c.ws.Close()
+	}
+}
+
+func (s *Server) ProgramScene() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.programScene
+}
+
+func (s *Server) SetProgramScene(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.programScene = name
+}
+
+func (s *Server) ReplayBufferActive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replayBufferActive
+}
+
+func (s *Server) InputSettings(inputName string) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inputSettings[inputName]
+}
+
+// SavedReplays returns the paths of files produced by SaveReplayBuffer.
+func (s *Server) SavedReplays() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.saved...)
+}
+
+// Requests returns the request types received so far, in order.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &conn{ws: ws}
+	defer ws.Close()
+
+	if err := c.send(opHello, map[string]any{
+		"obsWebSocketVersion": "5.5.0",
+		"rpcVersion":          1,
+	}); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	for {
+		var msg message
+		if err := ws.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		switch msg.Op {
+		case opIdentify:
+			_ = c.send(opIdentified, map[string]any{"negotiatedRpcVersion": 1})
+
+		case opRequest:
+			var req request
+			if err := json.Unmarshal(msg.D, &req); err != nil {
+				continue
+			}
+			s.handleRequest(c, req)
+		}
+	}
+}
+
+func (s *Server) handleRequest(c *conn, req request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req.Type)
+	s.mu.Unlock()
+
+	var params struct {
+		SceneName     string         `json:"sceneName"`
+		InputName     string         `json:"inputName"`
+		InputSettings map[string]any `json:"inputSettings"`
+		Overlay       *bool          `json:"overlay"`
+	}
+	if len(req.Data) > 0 {
+		_ = json.Unmarshal(req.Data, &params)
+	}
+
+	code := statusSuccess
+	var data any
+	var savedPath string
+	var bufferChanged bool
+
+	s.mu.Lock()
+	switch req.Type {
+	case "GetReplayBufferStatus":
+		data = map[string]any{"outputActive": s.replayBufferActive}
+
+	case "StartReplayBuffer":
+		if !s.replayBufferActive {
+			s.replayBufferActive = true
+			bufferChanged = true
+		}
+
+	case "StopReplayBuffer":
+		if !s.replayBufferActive {
+			code = statusOutputNotRunning
+			break
+		}
+		s.replayBufferActive = false
+		bufferChanged = true
+
+	case "SaveReplayBuffer":
+		if !s.replayBufferActive {
+			code = statusOutputNotRunning
+			break
+		}
+		savedPath = filepath.Join(s.Dir, fmt.Sprintf("Replay %03d.mkv", len(s.saved)+1))
+		if err := os.WriteFile(savedPath, []byte("fake replay\n"), 0o644); err != nil {
+			code = statusOutputNotRunning
+			savedPath = ""
+			break
+		}
+		s.saved = append(s.saved, savedPath)
+
+	case "GetCurrentProgramScene":
+		data = map[string]any{
+			"currentProgramSceneName": s.programScene,
+			"sceneName":               s.programScene,
+		}
+
+	case "SetCurrentProgramScene":
+		if params.SceneName == "" {
+			code = statusMissingRequestData
+			break
+		}
+		s.programScene = params.SceneName
+
+	case "GetInputSettings":
+		if params.InputName == "" {
+			code = statusMissingRequestData
+			break
+		}
+		data = map[string]any{
+			"inputKind":     "vlc_source",
+			"inputSettings": s.inputSettings[params.InputName],
+		}
+
+	case "SetInputSettings":
+		if params.InputName == "" {
+			code = statusMissingRequestData
+			break
+		}
+		cur := s.inputSettings[params.InputName]
+		if cur == nil || (params.Overlay != nil && !*params.Overlay) {
+			cur = make(map[string]any)
+		}
+		for k, v := range params.InputSettings {
+			cur[k] = v
+		}
+		s.inputSettings[params.InputName] = cur
+
+	default:
+		code = statusUnknownRequestType
+	}
+	bufferActive := s.replayBufferActive
+	s.mu.Unlock()
+
+	resp := map[string]any{
+		"requestType": req.Type,
+		"requestId":   req.ID,
+		"requestStatus": map[string]any{
+			"code":   code,
+			"result": code == statusSuccess,
+		},
+	}
+	if data != nil {
+		resp["responseData"] = data
+	}
+	_ = c.send(opRequestResponse, resp)
+
+	if bufferChanged {
+		state := "OBS_WEBSOCKET_OUTPUT_STOPPED"
+		if bufferActive {
+			state = "OBS_WEBSOCKET_OUTPUT_STARTED"
+		}
+		s.emit("ReplayBufferStateChanged", intentOutputs, map[string]any{"outputActive": bufferActive, "outputState": state})
+	}
+	if savedPath != "" {
+		s.emit("ReplayBufferSaved", intentOutputs, map[string]any{"savedReplayPath": savedPath})
+	}
+}
+
+func (s *Server) emit(eventType string, intent int, data any) {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		_ = c.send(opEvent, map[string]any{
+			"eventType":   eventType,
+			"eventIntent": intent,
+			"eventData":   data,
+		})
+	}
+}
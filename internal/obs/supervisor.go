@@ -0,0 +1,339 @@
+package obs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	obsEvents "github.com/andreykaipov/goobs/api/events"
+)
+
+const (
+	StateDisconnected = "disconnected"
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+)
+
+var ErrNotConnected = errors.New("obs is not connected")
+
+// Supervisor keeps a connection to OBS alive: it dials with exponential
+// backoff, forwards events from every connection to the Listen callback,
+// and restores the last requested replay buffer state after reconnecting.
+// It implements Client, failing calls with ErrNotConnected while offline.
+type Supervisor struct {
+	Address  string
+	Password string
+
+	Dial           func(address string, password string) (Client, error)
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+	HealthInterval time.Duration
+
+	// OnStatus is called on every connection state change.
+	OnStatus func(status domain.ObsStatus)
+
+	mu     sync.RWMutex
+	client Client
+	status domain.ObsStatus
+
+	// nil = never requested
+	wantReplayBuffer *bool
+
+	listenMu sync.Mutex
+	listener func(event any)
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func NewSupervisor(address string, password string) *Supervisor {
+	return &Supervisor{
+		Address:        address,
+		Password:       password,
+		Dial:           Dial,
+		MinBackoff:     500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		HealthInterval: 5 * time.Second,
+		status:         domain.ObsStatus{State: StateDisconnected, Address: address, Since: time.Now().UTC()},
+		stopped:        make(chan struct{}),
+	}
+}
+
+func (s *Supervisor) Status() domain.ObsStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+// Run connects and reconnects until ctx is done.
+func (s *Supervisor) Run(ctx context.Context) {
+	defer s.stop()
+
+	backoff := s.MinBackoff
+	attempts := 0
+
+	for {
+		attempts++
+		s.setStatus(StateConnecting, attempts, nil)
+
+		client, err := s.Dial(s.Address, s.Password)
+		if err != nil {
+			s.setStatus(StateDisconnected, attempts, err)
+			log.Printf("[OBS] connect to %s failed (attempt %d): %v; retrying in %s", s.Address, attempts, err, backoff)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > s.MaxBackoff {
+				backoff = s.MaxBackoff
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		s.client = client
+		s.mu.Unlock()
+
+		s.setStatus(StateConnected, attempts, nil)
+		log.Printf("[OBS] connected to %s", s.Address)
+
+		s.restoreReplayBuffer(client)
+		if active, err := client.ReplayBufferActive(); err == nil {
+			s.setReplayBuffer(active)
+		}
+
+		s.serve(ctx, client)
+
+		s.mu.Lock()
+		s.client = nil
+		s.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.setStatus(StateDisconnected, 0, errors.New("connection lost"))
+		log.Printf("[OBS] connection to %s lost; reconnecting", s.Address)
+
+		backoff = s.MinBackoff
+		attempts = 0
+	}
+}
+
+// serve forwards events until the connection drops, a health check fails
+// or ctx is done.
+func (s *Supervisor) serve(ctx context.Context, client Client) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Listen(s.dispatch)
+	}()
+
+	ticker := time.NewTicker(s.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			_ = client.Disconnect()
+			<-done
+			return
+		case <-done:
+			_ = client.Disconnect()
+			return
+		case <-ticker.C:
+			if _, err := client.CurrentProgramScene(); err != nil {
+				log.Printf("[OBS] health check failed: %v", err)
+				_ = client.Disconnect()
+				<-done
+				return
+			}
+		}
+	}
+}
+
+func (s *Supervisor) restoreReplayBuffer(client Client) {
+	s.mu.RLock()
+	want := s.wantReplayBuffer
+	s.mu.RUnlock()
+
+	if want == nil {
+		return
+	}
+
+	active, err := client.ReplayBufferActive()
+	if err != nil {
+		log.Printf("[OBS] replay buffer status after reconnect: %v", err)
+		return
+	}
+
+	switch {
+	case *want && !active:
+		err = client.StartReplayBuffer()
+	case !*want && active:
+		err = client.StopReplayBuffer()
+	}
+	if err != nil {
+		log.Printf("[OBS] restoring replay buffer state: %v", err)
+	}
+}
+
+func (s *Supervisor) setStatus(state string, attempts int, err error) {
+	s.mu.Lock()
+	s.status = domain.ObsStatus{
+		State:    state,
+		Address:  s.Address,
+		Since:    time.Now().UTC(),
+		Attempts: attempts,
+	}
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	status := s.status
+	s.mu.Unlock()
+
+	if s.OnStatus != nil {
+		s.OnStatus(status)
+	}
+}
+
+func (s *Supervisor) setReplayBuffer(active bool) {
+	s.mu.Lock()
+	if s.status.ReplayBuffer == active {
+		s.mu.Unlock()
+		return
+	}
+	s.status.ReplayBuffer = active
+	status := s.status
+	s.mu.Unlock()
+
+	if s.OnStatus != nil {
+		s.OnStatus(status)
+	}
+}
+
+func (s *Supervisor) dispatch(event any) {
+	if ev, ok := event.(*obsEvents.ReplayBufferStateChanged); ok {
+		s.setReplayBuffer(ev.OutputActive)
+	}
+
+	s.listenMu.Lock()
+	f := s.listener
+	s.listenMu.Unlock()
+
+	if f != nil {
+		f(event)
+	}
+}
+
+func (s *Supervisor) current() (Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.client == nil {
+		return nil, ErrNotConnected
+	}
+	return s.client, nil
+}
+
+func (s *Supervisor) setWantReplayBuffer(want bool) {
+	s.mu.Lock()
+	s.wantReplayBuffer = &want
+	s.mu.Unlock()
+}
+
+func (s *Supervisor) ReplayBufferActive() (bool, error) {
+	c, err := s.current()
+	if err != nil {
+		return false, err
+	}
+	return c.ReplayBufferActive()
+}
+
+func (s *Supervisor) StartReplayBuffer() error {
+	s.setWantReplayBuffer(true)
+	c, err := s.current()
+	if err != nil {
+		return err
+	}
+	return c.StartReplayBuffer()
+}
+
+func (s *Supervisor) StopReplayBuffer() error {
+	s.setWantReplayBuffer(false)
+	c, err := s.current()
+	if err != nil {
+		return err
+	}
+	return c.StopReplayBuffer()
+}
+
+func (s *Supervisor) SaveReplayBuffer() error {
+	c, err := s.current()
+	if err != nil {
+		return err
+	}
+	return c.SaveReplayBuffer()
+}
+
+func (s *Supervisor) CurrentProgramScene() (string, error) {
+	c, err := s.current()
+	if err != nil {
+		return "", err
+	}
+	return c.CurrentProgramScene()
+}
+
+func (s *Supervisor) SetCurrentProgramScene(sceneName string) error {
+	c, err := s.current()
+	if err != nil {
+		return err
+	}
+	return c.SetCurrentProgramScene(sceneName)
+}
+
+func (s *Supervisor) InputSettings(inputName string) (map[string]any, error) {
+	c, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	return c.InputSettings(inputName)
+}
+
+func (s *Supervisor) SetInputSettings(inputName string, settings map[string]any, overlay bool) error {
+	c, err := s.current()
+	if err != nil {
+		return err
+	}
+	return c.SetInputSettings(inputName, settings, overlay)
+}
+
+// Listen registers f for events from every (re)connection and blocks until
+// the supervisor stops.
+func (s *Supervisor) Listen(f func(event any)) {
+	s.listenMu.Lock()
+	s.listener = f
+	s.listenMu.Unlock()
+
+	<-s.stopped
+}
+
+// Disconnect closes the current connection; Run will reconnect unless its
+// context is done.
+func (s *Supervisor) Disconnect() error {
+	c, err := s.current()
+	if err != nil {
+		return nil
+	}
+	return c.Disconnect()
+}
+
+func (s *Supervisor) stop() {
+	s.stopOnce.Do(func() { close(s.stopped) })
+	s.setStatus(StateDisconnected, 0, nil)
+}
@@ -0,0 +1,89 @@
+package obs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/obs"
+	"github.com/akayumeru/valreplayserver/internal/obs/obstest"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSupervisorReconnectRestoresReplayBuffer(t *testing.T) {
+	srv := obstest.NewServer(t.TempDir())
+	defer srv.Close()
+
+	sup := obs.NewSupervisor(srv.Addr(), "")
+	sup.MinBackoff = 10 * time.Millisecond
+	sup.MaxBackoff = 50 * time.Millisecond
+	sup.HealthInterval = 50 * time.Millisecond
+
+	statuses := make(chan domain.ObsStatus, 64)
+	sup.OnStatus = func(s domain.ObsStatus) {
+		select {
+		case statuses <- s:
+		default:
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runDone := make(chan struct{})
+	go func() {
+		defer close(runDone)
+		sup.Run(ctx)
+	}()
+
+	waitFor(t, "first connection", func() bool { return sup.Status().State == obs.StateConnected })
+
+	if err := sup.StartReplayBuffer(); err != nil {
+		t.Fatalf("StartReplayBuffer: %v", err)
+	}
+	if !srv.ReplayBufferActive() {
+		t.Fatal("replay buffer is not active")
+	}
+
+	for len(statuses) > 0 {
+		<-statuses
+	}
+
+	srv.CloseConnections()
+
+	var sawLost bool
+	timeout := time.After(5 * time.Second)
+	for reconnected := false; !reconnected; {
+		select {
+		case s := <-statuses:
+			switch {
+			case s.State == obs.StateDisconnected && s.LastError != "":
+				sawLost = true
+			case s.State == obs.StateConnected && sawLost:
+				reconnected = true
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for reconnect")
+		}
+	}
+	waitFor(t, "replay buffer restore", srv.ReplayBufferActive)
+
+	cancel()
+	<-runDone
+
+	if got := sup.Status().State; got != obs.StateDisconnected {
+		t.Fatalf("state after stop = %q, want %q", got, obs.StateDisconnected)
+	}
+	if _, err := sup.CurrentProgramScene(); err != obs.ErrNotConnected {
+		t.Fatalf("CurrentProgramScene after stop: err = %v, want ErrNotConnected", err)
+	}
+}
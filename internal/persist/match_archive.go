@@ -0,0 +1,192 @@
+package persist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/sashka/atomicfile"
+)
+
+const (
+	ArchiveMatchEnd     = "match_end"
+	ArchiveMatchChanged = "match_changed" // a new match started without a match_end
+)
+
+var ErrMatchNotFound = errors.New("match not found")
+
+// ArchivedMatch is everything kept about a finished match. Replays and
+// PendingHighlights only hold highlights recorded during this match; their
+// MediaPath still points at the OBS recordings.
+type ArchivedMatch struct {
+	MatchID    string    `json:"matchId"`
+	ArchivedAt time.Time `json:"archivedAt"`
+	Reason     string    `json:"reason"`
+
+	PlayerInfo        domain.PlayerInfo        `json:"playerInfo"`
+	MatchInfo         domain.MatchInfo         `json:"matchInfo"`
+	Replays           map[uint32]domain.Replay `json:"replays"`
+	PendingHighlights []*domain.Highlight      `json:"pendingHighlights"`
+}
+
+type MatchSummary struct {
+	MatchID    string    `json:"matchId"`
+	Map        string    `json:"map"`
+	ArchivedAt time.Time `json:"archivedAt"`
+	Reason     string    `json:"reason"`
+	Outcome    string    `json:"outcome"`
+	RoundsWon  int       `json:"roundsWon"`
+	RoundsLost int       `json:"roundsLost"`
+	Rounds     int       `json:"rounds"`
+	Replays    int       `json:"replays"`
+}
+
+// NewArchivedMatch takes the match in st. st should be a copy the store no
+// longer mutates (see domain.State.Clone).
+func NewArchivedMatch(st domain.State, reason string, at time.Time) ArchivedMatch {
+	id := st.MatchInfo.MatchID
+	m := ArchivedMatch{
+		MatchID:    id,
+		ArchivedAt: at.UTC(),
+		Reason:     reason,
+		PlayerInfo: st.PlayerInfo,
+		MatchInfo:  st.MatchInfo,
+		Replays:    make(map[uint32]domain.Replay),
+	}
+
+	for rid, r := range st.ReplayState.Replays {
+		for _, hl := range r.Highlights {
+			if hl != nil && hl.MatchId == id {
+				m.Replays[rid] = r
+				break
+			}
+		}
+	}
+	for _, hl := range st.ReplayState.PendingHighlights {
+		if hl != nil && hl.MatchId == id {
+			m.PendingHighlights = append(m.PendingHighlights, hl)
+		}
+	}
+
+	return m
+}
+
+func (m ArchivedMatch) Summary() MatchSummary {
+	return MatchSummary{
+		MatchID:    m.MatchID,
+		Map:        m.MatchInfo.Map,
+		ArchivedAt: m.ArchivedAt,
+		Reason:     m.Reason,
+		Outcome:    m.MatchInfo.MatchOutcome,
+		RoundsWon:  m.MatchInfo.Ally.RoundsWon,
+		RoundsLost: m.MatchInfo.Enemy.RoundsWon,
+		Rounds:     len(m.MatchInfo.Rounds),
+		Replays:    len(m.Replays),
+	}
+}
+
+// MatchArchive keeps one JSON file per match in a directory.
+type MatchArchive struct {
+	dir string
+}
+
+func NewMatchArchive(dir string) (*MatchArchive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &MatchArchive{dir: dir}, nil
+}
+
+// path maps a match ID to its file; IDs are UUIDs from the game client, but
+// anything that could escape the directory is rejected.
+func (a *MatchArchive) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\:`) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid match id %q", id)
+	}
+	return filepath.Join(a.dir, id+".json"), nil
+}
+
+func (a *MatchArchive) Has(id string) bool {
+	p, err := a.path(id)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// Save writes m, replacing an earlier archive of the same match.
+func (a *MatchArchive) Save(m ArchivedMatch) error {
+	p, err := a.path(m.MatchID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	f, err := atomicfile.New(p, 0o666)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+
+	if _, err := f.Write(payload); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (a *MatchArchive) Load(id string) (ArchivedMatch, error) {
+	p, err := a.path(id)
+	if err != nil {
+		return ArchivedMatch{}, ErrMatchNotFound
+	}
+
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return ArchivedMatch{}, ErrMatchNotFound
+	}
+	if err != nil {
+		return ArchivedMatch{}, err
+	}
+
+	var m ArchivedMatch
+	if err := json.Unmarshal(b, &m); err != nil {
+		return ArchivedMatch{}, fmt.Errorf("%s: %w", p, err)
+	}
+	return m, nil
+}
+
+// List returns a summary of every archived match, newest first. Files that
+// fail to parse are skipped.
+func (a *MatchArchive) List() ([]MatchSummary, error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]MatchSummary, 0, len(entries))
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		m, err := a.Load(id)
+		if err != nil {
+			continue
+		}
+		out = append(out, m.Summary())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ArchivedAt.After(out[j].ArchivedAt) })
+
+	return out, nil
+}
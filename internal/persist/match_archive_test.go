@@ -0,0 +1,152 @@
+package persist
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+)
+
+func newTestArchive(t *testing.T) *MatchArchive {
+	t.Helper()
+
+	a, err := NewMatchArchive(filepath.Join(t.TempDir(), "matches"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestMatchArchiveRejectsPaths(t *testing.T) {
+	a := newTestArchive(t)
+
+	for _, id := range []string{"", "..", ".hidden", "../escape", `..\escape`, "a/b", `a\b`, "C:evil"} {
+		if _, err := a.path(id); err == nil {
+			t.Errorf("path(%q) accepted", id)
+		}
+		if err := a.Save(ArchivedMatch{MatchID: id}); err == nil {
+			t.Errorf("Save(%q) accepted", id)
+		}
+		if _, err := a.Load(id); !errors.Is(err, ErrMatchNotFound) {
+			t.Errorf("Load(%q) = %v, want ErrMatchNotFound", id, err)
+		}
+		if a.Has(id) {
+			t.Errorf("Has(%q) = true", id)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(a.dir), "escape.json")); err == nil {
+		t.Error("a file was written outside the archive")
+	}
+	if _, err := a.path("9f2c1d3e-0b7a-4c55-8e21-3d4f5a6b7c8d"); err != nil {
+		t.Errorf("match UUID rejected: %v", err)
+	}
+}
+
+func TestMatchArchiveSaveLoad(t *testing.T) {
+	a := newTestArchive(t)
+
+	st := domain.State{
+		PlayerInfo: domain.PlayerInfo{ID: "me", Name: "Yume#EUW"},
+		MatchInfo: domain.MatchInfo{
+			MatchID:      "m-1",
+			Map:          "Ascent",
+			MatchOutcome: "victory",
+			Ally:         domain.TeamState{RoundsWon: 13},
+			Enemy:        domain.TeamState{RoundsWon: 7},
+			Rounds: map[int]*domain.Round{
+				1: {Number: 1, Outcome: "win", Kills: 2},
+				2: {Number: 2, Outcome: "loss"},
+			},
+		},
+		ReplayState: domain.ReplayState{
+			Replays: map[uint32]domain.Replay{
+				1: {Highlights: []*domain.Highlight{{MatchId: "m-1", MediaPath: "a.mp4"}}},
+				2: {Highlights: []*domain.Highlight{{MatchId: "older", MediaPath: "b.mp4"}}},
+			},
+			PendingHighlights: []*domain.Highlight{
+				{MatchId: "m-1", MediaPath: "c.mp4"},
+				{MatchId: "older", MediaPath: "d.mp4"},
+			},
+		},
+	}
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	m := NewArchivedMatch(st, ArchiveMatchEnd, at)
+	if len(m.Replays) != 1 || len(m.PendingHighlights) != 1 {
+		t.Fatalf("kept %d replays and %d pending highlights, want only those of m-1", len(m.Replays), len(m.PendingHighlights))
+	}
+
+	if a.Has("m-1") {
+		t.Fatal("Has before Save")
+	}
+	if err := a.Save(m); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Has("m-1") {
+		t.Fatal("not Has after Save")
+	}
+
+	got, err := a.Load("m-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("Load = %+v\nwant %+v", got, m)
+	}
+
+	want := MatchSummary{
+		MatchID:    "m-1",
+		Map:        "Ascent",
+		ArchivedAt: at,
+		Reason:     ArchiveMatchEnd,
+		Outcome:    "victory",
+		RoundsWon:  13,
+		RoundsLost: 7,
+		Rounds:     2,
+		Replays:    1,
+	}
+	if s := got.Summary(); s != want {
+		t.Errorf("Summary = %+v, want %+v", s, want)
+	}
+
+	if _, err := a.Load("m-2"); !errors.Is(err, ErrMatchNotFound) {
+		t.Errorf("Load of a missing match = %v, want ErrMatchNotFound", err)
+	}
+}
+
+func TestMatchArchiveList(t *testing.T) {
+	a := newTestArchive(t)
+
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"b", "c", "a"} {
+		m := ArchivedMatch{MatchID: id, ArchivedAt: base.Add(time.Duration(i) * time.Hour)}
+		if err := a.Save(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// neither is a match; List skips both
+	if err := os.WriteFile(filepath.Join(a.dir, "broken.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(a.dir, "dir.json"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := a.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, s := range list {
+		ids = append(ids, s.MatchID)
+	}
+	if want := []string{"a", "c", "b"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("List order = %v, want newest first %v", ids, want)
+	}
+}
@@ -0,0 +1,348 @@
+package render
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+)
+
+// JSON data topics are the machine-readable counterpart of the screens, for
+// overlays that render state themselves. Every message is a DataMessage:
+//
+//	{"schema": 1, "topic": "round", "data": {...}}
+//
+// data is a full snapshot of the topic, never a delta, and is null when
+// there is nothing to show (e.g. "round" between matches). The types below
+// are the schema; their JSON field names are stable within a schema
+// version. DataSchemaVersion only changes when a field is removed, renamed
+// or changes meaning; new fields may appear at any time.
+const DataSchemaVersion = 1
+
+const (
+	DataMatchInfo  = "match_info"
+	DataRoster     = "roster"
+	DataScoreboard = "scoreboard"
+	DataKillFeed   = "kill_feed"
+	DataRound      = "round"
+	DataReplays    = "replays"
+)
+
+var DataTopics = []string{
+	DataMatchInfo,
+	DataRoster,
+	DataScoreboard,
+	DataKillFeed,
+	DataRound,
+	DataReplays,
+}
+
+func IsDataTopic(topic string) bool {
+	for _, t := range DataTopics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+type DataMessage struct {
+	Schema int    `json:"schema"`
+	Topic  string `json:"topic"`
+	Data   any    `json:"data"`
+}
+
+type MatchInfoData struct {
+	MatchID      string   `json:"matchId"`
+	Map          string   `json:"map"`
+	Scene        string   `json:"scene"`
+	GameState    string   `json:"gameState"`
+	Half         int      `json:"half"` // 1 or 2, 0 in overtime
+	Overtime     bool     `json:"overtime"`
+	Ally         TeamData `json:"ally"` // the local player's team
+	Enemy        TeamData `json:"enemy"`
+	MatchOutcome string   `json:"matchOutcome"` // "", victory or defeat
+}
+
+type TeamData struct {
+	RoundsWon int    `json:"roundsWon"`
+	Side      string `json:"side"` // "", attack or defense
+}
+
+// RosterData lists each team local player first, then by name.
+type RosterData struct {
+	Allies  []RosterPlayerData `json:"allies"`
+	Enemies []RosterPlayerData `json:"enemies"`
+}
+
+type RosterPlayerData struct {
+	PlayerID string `json:"playerId"`
+	Name     string `json:"name"`
+	Agent    string `json:"agent"`
+	Rank     int    `json:"rank"`
+	Locked   bool   `json:"locked"`
+	Local    bool   `json:"local"`
+}
+
+// ScoreboardData lists allies first, then by kills.
+type ScoreboardData struct {
+	Players []ScoreboardPlayerData `json:"players"`
+}
+
+type ScoreboardPlayerData struct {
+	PlayerID  string `json:"playerId"`
+	Name      string `json:"name"`
+	Agent     string `json:"agent"`
+	Teammate  bool   `json:"teammate"`
+	Local     bool   `json:"local"`
+	Alive     bool   `json:"alive"`
+	Kills     int    `json:"kills"`
+	Deaths    int    `json:"deaths"`
+	Assists   int    `json:"assists"`
+	Money     int    `json:"money"`
+	UltPoints int    `json:"ultPoints"`
+	UltMax    int    `json:"ultMax"`
+	Shield    int    `json:"shield"`
+	Weapon    string `json:"weapon"`
+}
+
+// KillFeedData holds the last kills of the match, newest first.
+type KillFeedData struct {
+	Entries []KillData `json:"entries"`
+}
+
+type KillData struct {
+	Attacker         string   `json:"attacker"`
+	Victim           string   `json:"victim"`
+	Assists          []string `json:"assists"`
+	Ult              string   `json:"ult"`
+	Headshot         bool     `json:"headshot"`
+	Weapon           string   `json:"weapon"`
+	AttackerTeammate bool     `json:"attackerTeammate"`
+	VictimTeammate   bool     `json:"victimTeammate"`
+}
+
+// RoundData is the round in progress; Player holds the local player's stats.
+type RoundData struct {
+	Number         int             `json:"number"`
+	Phase          string          `json:"phase"` // shopping, combat, end
+	PhaseStartedAt time.Time       `json:"phaseStartedAt"`
+	StartedAt      time.Time       `json:"startedAt"`
+	Outcome        string          `json:"outcome"` // "", win or loss
+	Spike          SpikeData       `json:"spike"`
+	Player         RoundPlayerData `json:"player"`
+	History        []RoundResult   `json:"history"` // finished rounds, oldest first
+}
+
+type SpikeData struct {
+	PlantedAt   *time.Time `json:"plantedAt"`
+	DefusedAt   *time.Time `json:"defusedAt"`
+	DetonatedAt *time.Time `json:"detonatedAt"`
+}
+
+type RoundPlayerData struct {
+	Kills     int `json:"kills"`
+	Deaths    int `json:"deaths"`
+	Assists   int `json:"assists"`
+	Headshots int `json:"headshots"`
+	Damage    int `json:"damage"`
+}
+
+type RoundResult struct {
+	Number  int    `json:"number"`
+	Outcome string `json:"outcome"`
+}
+
+type ReplaysData struct {
+	Playing           bool                `json:"playing"`
+	PlayingReplayID   *uint32             `json:"playingReplayId"`
+	PendingHighlights int                 `json:"pendingHighlights"`
+	Replays           []ReplaySummaryData `json:"replays"` // newest first
+}
+
+type ReplaySummaryData struct {
+	ID          uint32 `json:"id"`
+	RoundNumber int    `json:"roundNumber"`
+	Highlights  int    `json:"highlights"`
+}
+
+// RenderData encodes topic as a DataMessage; false for unknown topics.
+func RenderData(topic string, st domain.State) ([]byte, bool) {
+	var data any
+	switch topic {
+	case DataMatchInfo:
+		data = newMatchInfoData(st)
+	case DataRoster:
+		data = newRosterData(st)
+	case DataScoreboard:
+		data = newScoreboardData(st)
+	case DataKillFeed:
+		data = newKillFeedData(st)
+	case DataRound:
+		if rd := newRoundData(st); rd != nil {
+			data = rd
+		}
+	case DataReplays:
+		data = newReplaysData(st)
+	default:
+		return nil, false
+	}
+
+	b, err := json.Marshal(DataMessage{Schema: DataSchemaVersion, Topic: topic, Data: data})
+	if err != nil {
+		// only plain structs above; cannot happen
+		panic(err)
+	}
+	return b, true
+}
+
+func newMatchInfoData(st domain.State) MatchInfoData {
+	mi := st.MatchInfo
+	return MatchInfoData{
+		MatchID:      mi.MatchID,
+		Map:          mi.Map,
+		Scene:        st.GameInfo.Scene,
+		GameState:    st.GameInfo.State,
+		Half:         mi.Half,
+		Overtime:     mi.Overtime,
+		Ally:         TeamData(mi.Ally),
+		Enemy:        TeamData(mi.Enemy),
+		MatchOutcome: mi.MatchOutcome,
+	}
+}
+
+func newRosterData(st domain.State) RosterData {
+	v := newPlayerPicksView(st)
+	out := RosterData{
+		Allies:  make([]RosterPlayerData, 0, len(v.Allies)),
+		Enemies: make([]RosterPlayerData, 0, len(v.Enemies)),
+	}
+	for _, p := range v.Allies {
+		out.Allies = append(out.Allies, newRosterPlayerData(p))
+	}
+	for _, p := range v.Enemies {
+		out.Enemies = append(out.Enemies, newRosterPlayerData(p))
+	}
+	return out
+}
+
+func newRosterPlayerData(p domain.RosterPlayer) RosterPlayerData {
+	return RosterPlayerData{
+		PlayerID: p.PlayerID,
+		Name:     p.Name,
+		Agent:    p.Character,
+		Rank:     p.Rank,
+		Locked:   p.Locked,
+		Local:    p.Local,
+	}
+}
+
+func newScoreboardData(st domain.State) ScoreboardData {
+	entries := newScoreboardView(st).Entries
+	out := ScoreboardData{Players: make([]ScoreboardPlayerData, 0, len(entries))}
+	for _, e := range entries {
+		out.Players = append(out.Players, ScoreboardPlayerData{
+			PlayerID:  e.PlayerID,
+			Name:      e.Name,
+			Agent:     e.Character,
+			Teammate:  e.Teammate,
+			Local:     e.IsLocal,
+			Alive:     e.Alive,
+			Kills:     e.Kills,
+			Deaths:    e.Deaths,
+			Assists:   e.Assists,
+			Money:     e.Money,
+			UltPoints: e.UltPoints,
+			UltMax:    e.UltMax,
+			Shield:    e.Shield,
+			Weapon:    e.Weapon,
+		})
+	}
+	return out
+}
+
+func newKillFeedData(st domain.State) KillFeedData {
+	entries := newKillFeedView(st).Entries
+	out := KillFeedData{Entries: make([]KillData, 0, len(entries))}
+	for _, k := range entries {
+		assists := make([]string, 0, 4)
+		for _, a := range []string{k.Assist1, k.Assist2, k.Assist3, k.Assist4} {
+			if a != "" {
+				assists = append(assists, a)
+			}
+		}
+		out.Entries = append(out.Entries, KillData{
+			Attacker:         k.Attacker,
+			Victim:           k.Victim,
+			Assists:          assists,
+			Ult:              k.Ult,
+			Headshot:         k.Headshot,
+			Weapon:           k.Weapon,
+			AttackerTeammate: k.IsAttackerTeammate,
+			VictimTeammate:   k.IsVictimTeammate,
+		})
+	}
+	return out
+}
+
+func newRoundData(st domain.State) *RoundData {
+	cr := st.MatchInfo.CurrentRound
+	if cr == nil {
+		return nil
+	}
+
+	rd := &RoundData{
+		Number:         cr.Number,
+		Phase:          cr.LastPhase,
+		PhaseStartedAt: cr.PhaseStartedAt,
+		StartedAt:      cr.StartedAt,
+		Outcome:        cr.Outcome,
+		Spike: SpikeData{
+			PlantedAt:   timeOrNil(cr.SpikePlantedAt),
+			DefusedAt:   timeOrNil(cr.SpikeDefusedAt),
+			DetonatedAt: timeOrNil(cr.SpikeDetonatedAt),
+		},
+		Player: RoundPlayerData{
+			Kills:     cr.Kills,
+			Deaths:    cr.Deaths,
+			Assists:   cr.Assists,
+			Headshots: cr.Headshots,
+			Damage:    cr.Damage,
+		},
+		History: make([]RoundResult, 0, len(st.MatchInfo.Rounds)),
+	}
+
+	for n, r := range st.MatchInfo.Rounds {
+		if r != nil && n < cr.Number {
+			rd.History = append(rd.History, RoundResult{Number: n, Outcome: r.Outcome})
+		}
+	}
+	sort.Slice(rd.History, func(i, j int) bool { return rd.History[i].Number < rd.History[j].Number })
+
+	return rd
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func newReplaysData(st domain.State) ReplaysData {
+	v := newControlView(st)
+	out := ReplaysData{
+		Playing:           st.Playback.Playing,
+		PendingHighlights: len(v.Pending),
+		Replays:           make([]ReplaySummaryData, 0, len(v.Replays)),
+	}
+	if st.Playback.Playing {
+		id := st.Playback.ReplayID
+		out.PlayingReplayID = &id
+	}
+	for _, r := range v.Replays {
+		out.Replays = append(out.Replays, ReplaySummaryData(r))
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/data/golden.json")
+
+func dataTestState() domain.State {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	round := &domain.Round{
+		Number:         3,
+		StartedAt:      start,
+		LastPhase:      "combat",
+		PhaseStartedAt: start.Add(30 * time.Second),
+		SpikePlantedAt: start.Add(75 * time.Second),
+		Kills:          2,
+		Assists:        1,
+		Headshots:      1,
+		Damage:         260,
+	}
+
+	return domain.State{
+		GameInfo: domain.GameInfo{Scene: "ingame", State: "ingame"},
+		MatchInfo: domain.MatchInfo{
+			MatchID:      "m-1",
+			Map:          "Ascent",
+			CurrentRound: round,
+			Rounds: map[int]*domain.Round{
+				2: {Number: 2, Outcome: "loss"},
+				1: {Number: 1, Outcome: "win"},
+				3: round,
+			},
+			Roster: map[string]domain.RosterPlayer{
+				"p1": {PlayerID: "p1", Name: "Yume#EUW", Character: "Jett", Rank: 21, Locked: true, Local: true, Teammate: true},
+				"p2": {PlayerID: "p2", Name: "Ally#EUW", Character: "Sage", Rank: 18, Locked: true, Teammate: true},
+				"p3": {PlayerID: "p3", Name: "Foe#KR", Character: "Reyna", Rank: 20, Locked: true},
+			},
+			Scoreboard: map[string]domain.ScoreboardEntry{
+				"p1": {PlayerID: "p1", Name: "Yume#EUW", Character: "Jett", Teammate: true, IsLocal: true, Alive: true, Kills: 7, Deaths: 3, Money: 3900, UltPoints: 5, UltMax: 7, Shield: 50, Weapon: "Vandal"},
+				"p2": {PlayerID: "p2", Name: "Ally#EUW", Character: "Sage", Teammate: true, Kills: 2, Deaths: 5, Assists: 4, Weapon: "Spectre"},
+				"p3": {PlayerID: "p3", Name: "Foe#KR", Character: "Reyna", Alive: true, Kills: 8, Deaths: 4, Money: 800, Weapon: "Sheriff"},
+			},
+			KillFeed: []domain.KillFeedEntry{
+				{Attacker: "Foe#KR", Victim: "Ally#EUW", Weapon: "Sheriff", Headshot: true, IsVictimTeammate: true},
+				{Attacker: "Yume#EUW", Victim: "Foe#KR", Assist1: "Ally#EUW", Ult: "Blade Storm", IsAttackerTeammate: true},
+			},
+			Ally:  domain.TeamState{RoundsWon: 1, Side: "attack"},
+			Enemy: domain.TeamState{RoundsWon: 1, Side: "defense"},
+			Half:  1,
+		},
+		ReplayState: domain.ReplayState{
+			PendingHighlights: []*domain.Highlight{{MatchId: "m-1", Round: 3}},
+			Replays: map[uint32]domain.Replay{
+				1: {RoundNumber: 1, Highlights: []*domain.Highlight{{MatchId: "m-1"}, {MatchId: "m-1"}}},
+				2: {RoundNumber: 2, Highlights: []*domain.Highlight{{MatchId: "m-1"}, nil}},
+			},
+		},
+		Playback: domain.Playback{Playing: true, ReplayID: 2},
+	}
+}
+
+// TestRenderDataGolden pins the JSON schema of every data topic, for a
+// match in progress and for the empty state. Run with -update after a
+// deliberate change, and bump DataSchemaVersion if a field was removed,
+// renamed or changed meaning.
+func TestRenderDataGolden(t *testing.T) {
+	states := []struct {
+		name string
+		st   domain.State
+	}{
+		{"match", dataTestState()},
+		{"empty", domain.State{}},
+	}
+
+	var b bytes.Buffer
+	b.WriteString("{\n")
+	for i, s := range states {
+		for j, topic := range DataTopics {
+			msg, ok := RenderData(topic, s.st)
+			if !ok {
+				t.Fatalf("RenderData(%q) not ok", topic)
+			}
+
+			var parsed DataMessage
+			if err := json.Unmarshal(msg, &parsed); err != nil {
+				t.Fatalf("%s/%s: %v", s.name, topic, err)
+			}
+			if parsed.Schema != DataSchemaVersion || parsed.Topic != topic {
+				t.Errorf("%s/%s: envelope says schema %d, topic %q", s.name, topic, parsed.Schema, parsed.Topic)
+			}
+
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, msg, "  ", "  "); err != nil {
+				t.Fatal(err)
+			}
+			sep := ","
+			if i == len(states)-1 && j == len(DataTopics)-1 {
+				sep = ""
+			}
+			b.WriteString("  \"" + s.name + "/" + topic + "\": ")
+			b.Write(indented.Bytes())
+			b.WriteString(sep + "\n")
+		}
+	}
+	b.WriteString("}\n")
+
+	goldenPath := filepath.Join("testdata", "data", "golden.json")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(goldenPath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(goldenPath, b.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(want, b.Bytes()) {
+		t.Errorf("RenderData output differs from %s (run with -update to accept); got:\n%s", goldenPath, b.String())
+	}
+}
+
+func TestRenderDataUnknownTopic(t *testing.T) {
+	if _, ok := RenderData("obs_status", domain.State{}); ok {
+		t.Error("RenderData accepted a topic that is not a data topic")
+	}
+}
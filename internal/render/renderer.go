@@ -2,78 +2,249 @@ package render
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
-	"strconv"
+	"io/fs"
+	"log"
+	"sort"
+	"sync"
 
 	"github.com/akayumeru/valreplayserver/internal/domain"
 )
 
+const (
+	ScreenPlayerPicks = "player_picks"
+	ScreenMatchInfo   = "match_info"
+	ScreenScoreboard  = "scoreboard"
+	ScreenKillFeed    = "kill_feed"
+	ScreenObsStatus   = "obs_status"
+	ScreenControl     = "control"
+)
+
+var screens = []string{
+	ScreenPlayerPicks,
+	ScreenMatchInfo,
+	ScreenScoreboard,
+	ScreenKillFeed,
+	ScreenObsStatus,
+	ScreenControl,
+}
+
+// Each screens/<name>.html is a page that also defines a "fragment" block
+// (the SSE update, including the #content wrapper). The page renders its
+// initial content with {{template "fragment" .}}, so both share one source.
 type Renderer struct {
-	playerPicksPage  *template.Template
-	matchInfoPage    *template.Template
-	matchResultsPage *template.Template
+	fsys fs.FS
+
+	mu    sync.RWMutex
+	pages map[string]*template.Template
 }
 
-func NewRenderer() (*Renderer, error) {
-	pp, err := template.ParseFiles("web/templates/screens/player_picks.html")
-	if err != nil {
+// NewRenderer parses screen templates from fsys, which is rooted at the
+// templates directory (see web.Templates for the embedded copy).
+func NewRenderer(fsys fs.FS) (*Renderer, error) {
+	r := &Renderer{fsys: fsys}
+	if err := r.Reload(); err != nil {
 		return nil, err
 	}
+	return r, nil
+}
 
-	mi, err := template.ParseFiles("web/templates/screens/match_info.html")
-	if err != nil {
-		return nil, err
+// Reload re-parses all templates; on error the previous set is kept.
+func (r *Renderer) Reload() error {
+	pages := make(map[string]*template.Template, len(screens))
+	for _, name := range screens {
+		t, err := template.ParseFS(r.fsys, "screens/"+name+".html")
+		if err != nil {
+			return err
+		}
+		if t.Lookup("fragment") == nil {
+			return fmt.Errorf("screens/%s.html: missing {{define \"fragment\"}}", name)
+		}
+		pages[name] = t
 	}
 
-	return &Renderer{
-		playerPicksPage: pp,
-		matchInfoPage:   mi,
-	}, nil
+	r.mu.Lock()
+	r.pages = pages
+	r.mu.Unlock()
+
+	return nil
 }
 
 func (r *Renderer) RenderPlayerPicksPage(st domain.State) ([]byte, error) {
-	return execute(r.playerPicksPage, st)
+	return r.renderPage(ScreenPlayerPicks, newPlayerPicksView(st))
 }
 
 func (r *Renderer) RenderMatchInfoPage(st domain.State) ([]byte, error) {
-	return execute(r.matchInfoPage, st)
+	return r.renderPage(ScreenMatchInfo, st)
 }
 
-func (r *Renderer) RenderPlayerPicksFragment(st domain.State) []byte {
-	var b bytes.Buffer
+func (r *Renderer) RenderScoreboardPage(st domain.State) ([]byte, error) {
+	return r.renderPage(ScreenScoreboard, newScoreboardView(st))
+}
+
+func (r *Renderer) RenderKillFeedPage(st domain.State) ([]byte, error) {
+	return r.renderPage(ScreenKillFeed, newKillFeedView(st))
+}
+
+func (r *Renderer) RenderObsStatusPage(st domain.State) ([]byte, error) {
+	return r.renderPage(ScreenObsStatus, st)
+}
 
-	b.WriteString(`<div id="content">`)
-	b.WriteString(`<h1>Player picks</h1>`)
-	b.WriteString(`</div>`)
+func (r *Renderer) RenderControlPage(st domain.State) ([]byte, error) {
+	return r.renderPage(ScreenControl, newControlView(st))
+}
 
-	return b.Bytes()
+func (r *Renderer) RenderPlayerPicksFragment(st domain.State) []byte {
+	return r.renderFragment(ScreenPlayerPicks, "fragment", newPlayerPicksView(st))
 }
 
 func (r *Renderer) RenderMatchInfoFragment(st domain.State) []byte {
-	var b bytes.Buffer
+	return r.renderFragment(ScreenMatchInfo, "fragment", st)
+}
+
+func (r *Renderer) RenderScoreboardFragment(st domain.State) []byte {
+	return r.renderFragment(ScreenScoreboard, "fragment", newScoreboardView(st))
+}
+
+// RenderKillFeedFragment renders the whole feed, newest first. Later entries
+// are pushed one by one with RenderKillFeedEntryFragment.
+func (r *Renderer) RenderKillFeedFragment(st domain.State) []byte {
+	return r.renderFragment(ScreenKillFeed, "fragment", newKillFeedView(st))
+}
+
+func (r *Renderer) RenderKillFeedEntryFragment(k domain.KillFeedEntry) []byte {
+	return r.renderFragment(ScreenKillFeed, "entry", k)
+}
+
+func (r *Renderer) RenderObsStatusFragment(st domain.State) []byte {
+	return r.renderFragment(ScreenObsStatus, "fragment", st)
+}
+
+func (r *Renderer) RenderControlFragment(st domain.State) []byte {
+	return r.renderFragment(ScreenControl, "fragment", newControlView(st))
+}
+
+type playerPicksView struct {
+	domain.State
+	Allies  []domain.RosterPlayer
+	Enemies []domain.RosterPlayer
+}
+
+func newPlayerPicksView(st domain.State) playerPicksView {
+	v := playerPicksView{State: st}
+	for _, p := range st.MatchInfo.Roster {
+		if p.Teammate {
+			v.Allies = append(v.Allies, p)
+		} else {
+			v.Enemies = append(v.Enemies, p)
+		}
+	}
+
+	for _, players := range [][]domain.RosterPlayer{v.Allies, v.Enemies} {
+		sort.Slice(players, func(i, j int) bool {
+			if players[i].Local != players[j].Local {
+				return players[i].Local
+			}
+			return players[i].Name < players[j].Name
+		})
+	}
+
+	return v
+}
+
+type scoreboardView struct {
+	domain.State
+	Entries []domain.ScoreboardEntry
+}
+
+func newScoreboardView(st domain.State) scoreboardView {
+	entries := make([]domain.ScoreboardEntry, 0, len(st.MatchInfo.Scoreboard))
+	for _, e := range st.MatchInfo.Scoreboard {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Teammate != entries[j].Teammate {
+			return entries[i].Teammate
+		}
+		if entries[i].Kills != entries[j].Kills {
+			return entries[i].Kills > entries[j].Kills
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	return scoreboardView{State: st, Entries: entries}
+}
+
+type killFeedView struct {
+	domain.State
+	Entries []domain.KillFeedEntry // newest first
+}
 
-	b.WriteString(`<div id="content">`)
-	b.WriteString(`<h1>Match Info</h1>`)
-	b.WriteString(`<p>Map: `)
-	b.WriteString(template.HTMLEscapeString(st.MatchInfo.Map))
-	b.WriteString(`</p>`)
-	if st.MatchInfo.CurrentRound != nil {
-		b.WriteString(`<p>Round: `)
-		b.WriteString(template.HTMLEscapeString(strconv.Itoa(int(st.MatchInfo.CurrentRound.Number))))
-		b.WriteString(` (`)
-		b.WriteString(template.HTMLEscapeString(string(st.MatchInfo.CurrentRound.LastPhase)))
-		b.WriteString(`)`)
-		b.WriteString(`</p>`)
+func newKillFeedView(st domain.State) killFeedView {
+	feed := st.MatchInfo.KillFeed
+	entries := make([]domain.KillFeedEntry, 0, len(feed))
+	for i := len(feed) - 1; i >= 0; i-- {
+		entries = append(entries, feed[i])
 	}
-	b.WriteString(`</div>`)
+	return killFeedView{State: st, Entries: entries}
+}
+
+type controlReplay struct {
+	ID          uint32
+	RoundNumber int
+	Highlights  int
+}
 
-	return b.Bytes()
+type controlView struct {
+	domain.State
+	Pending []*domain.Highlight
+	Replays []controlReplay // newest first
 }
 
-func execute(t *template.Template, st domain.State) ([]byte, error) {
+func newControlView(st domain.State) controlView {
+	v := controlView{State: st}
+	for _, hl := range st.ReplayState.PendingHighlights {
+		if hl != nil {
+			v.Pending = append(v.Pending, hl)
+		}
+	}
+
+	for id, replay := range st.ReplayState.Replays {
+		n := 0
+		for _, hl := range replay.Highlights {
+			if hl != nil {
+				n++
+			}
+		}
+		v.Replays = append(v.Replays, controlReplay{ID: id, RoundNumber: replay.RoundNumber, Highlights: n})
+	}
+	sort.Slice(v.Replays, func(i, j int) bool { return v.Replays[i].ID > v.Replays[j].ID })
+
+	return v
+}
+
+func (r *Renderer) renderPage(screen string, data any) ([]byte, error) {
+	r.mu.RLock()
+	t := r.pages[screen]
+	r.mu.RUnlock()
+
 	var b bytes.Buffer
-	if err := t.Execute(&b, st); err != nil {
+	if err := t.Execute(&b, data); err != nil {
 		return nil, err
 	}
 	return b.Bytes(), nil
 }
+
+func (r *Renderer) renderFragment(screen string, name string, data any) []byte {
+	r.mu.RLock()
+	t := r.pages[screen]
+	r.mu.RUnlock()
+
+	var b bytes.Buffer
+	if err := t.ExecuteTemplate(&b, name, data); err != nil {
+		log.Printf("render %s/%s failed: %v", screen, name, err)
+		return nil
+	}
+	return bytes.TrimSpace(b.Bytes())
+}
@@ -0,0 +1,62 @@
+package render
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WatchDir polls dir and calls onChange after any file under it is added,
+// removed or modified.
+func WatchDir(ctx context.Context, dir string, interval time.Duration, onChange func()) {
+	last := dirFingerprint(dir)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cur := dirFingerprint(dir)
+			if cur == last {
+				continue
+			}
+			last = cur
+			onChange()
+		}
+	}
+}
+
+type fingerprint struct {
+	files   int
+	size    int64
+	modTime time.Time
+}
+
+func dirFingerprint(dir string) fingerprint {
+	var fp fingerprint
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fp.files++
+		fp.size += info.Size()
+		if info.ModTime().After(fp.modTime) {
+			fp.modTime = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("[Templates] watch %s: %v", dir, err)
+	}
+	return fp
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"math"
 	"net/url"
+	"sort"
 
 	"github.com/akayumeru/valreplayserver/internal/domain"
 	"github.com/akayumeru/valreplayserver/internal/store"
@@ -14,13 +15,23 @@ import (
 type Builder struct {
 	Store   *store.StateStore
 	BaseURL *url.URL
+
+	// OnChange, when set, is called with the new state after each change to
+	// the replays or highlights.
+	OnChange func(st domain.State)
+}
+
+func (b *Builder) changed(st domain.State) {
+	if b.OnChange != nil {
+		b.OnChange(st)
+	}
 }
 
 func (b *Builder) CreateReplay() (uint32, string, error) {
 	var createdID uint32
 	var notCreated bool
 
-	b.Store.Update(func(cur domain.State) domain.State {
+	next := b.Store.Update("replay_created", func(cur domain.State) domain.State {
 		notCreated = true
 
 		if len(cur.ReplayState.PendingHighlights) == 0 || cur.ReplayState.CurrentReplayId == math.MaxUint32 {
@@ -42,11 +53,12 @@ func (b *Builder) CreateReplay() (uint32, string, error) {
 			hlsPending[hl.StartTime] = hl
 		}
 
-		hlsForReplay := make([]*domain.Highlight, len(hlsPending))
+		hlsForReplay := make([]*domain.Highlight, 0, len(hlsPending))
 
 		for _, hl := range hlsPending {
 			hlsForReplay = append(hlsForReplay, hl)
 		}
+		sort.Slice(hlsForReplay, func(i, j int) bool { return hlsForReplay[i].StartTime < hlsForReplay[j].StartTime })
 
 		var roundNumber = 0
 		if cur.MatchInfo.CurrentRound != nil {
@@ -64,10 +76,7 @@ func (b *Builder) CreateReplay() (uint32, string, error) {
 			Highlights:  hlsForReplay,
 		}
 
-		if cur.ReplayState.Replays == nil {
-			cur.ReplayState.Replays = make(map[uint32]domain.Replay)
-		}
-
+		cur.ReplayState.Replays = copyReplays(cur.ReplayState.Replays)
 		cur.ReplayState.Replays[createdID] = replay
 		cur.ReplayState.PendingHighlights = nil
 		cur.ReplayState.CurrentReplayId++
@@ -77,16 +86,173 @@ func (b *Builder) CreateReplay() (uint32, string, error) {
 	})
 
 	if notCreated {
-		return 0, "", errors.New("replay was not created")
+		return 0, "", ErrReplayNotCreated
 	}
+	b.changed(next)
+
+	u := b.ReplayURL(createdID)
+
+	log.Printf("Created replay with id %d (%s)\n", createdID, u)
 
+	return createdID, u, nil
+}
+
+func (b *Builder) ReplayURL(id uint32) string {
 	u := *b.BaseURL
 	u.Path = "/replay.ts"
 	q := u.Query()
-	q.Set("replay_id", fmt.Sprintf("%d", createdID))
+	q.Set("replay_id", fmt.Sprintf("%d", id))
 	u.RawQuery = q.Encode()
 
-	log.Printf("Created replay with id %d (%s)\n", createdID, u.String())
+	return u.String()
+}
+
+var (
+	ErrReplayNotCreated  = errors.New("replay was not created")
+	ErrReplayNotFound    = errors.New("replay not found")
+	ErrHighlightNotFound = errors.New("highlight not found")
+)
+
+func (b *Builder) DeleteReplay(id uint32) error {
+	var found bool
+
+	next := b.Store.Update("replay_deleted", func(cur domain.State) domain.State {
+		if _, found = cur.ReplayState.Replays[id]; found {
+			cur.ReplayState.Replays = copyReplays(cur.ReplayState.Replays)
+			delete(cur.ReplayState.Replays, id)
+		}
+		return cur
+	})
+
+	if !found {
+		return ErrReplayNotFound
+	}
+	b.changed(next)
+	return nil
+}
+
+// Highlight finds the highlight with the given start time in the pending
+// list or whichever replay holds it.
+func (b *Builder) Highlight(startTime uint64) (*domain.Highlight, error) {
+	hl := findHighlight(b.Store.Get(), startTime)
+	if hl == nil {
+		return nil, ErrHighlightNotFound
+	}
+	return hl, nil
+}
+
+// DeleteHighlight removes the highlight with the given start time from the
+// pending list or whichever replay holds it.
+func (b *Builder) DeleteHighlight(startTime uint64) error {
+	var found bool
+
+	next := b.Store.Update("highlight_deleted", func(cur domain.State) domain.State {
+		var hl *domain.Highlight
+		cur, hl = takeHighlight(cur, startTime)
+		found = hl != nil
+		return cur
+	})
+
+	if !found {
+		return ErrHighlightNotFound
+	}
+	b.changed(next)
+	return nil
+}
+
+// MoveHighlight moves a highlight into replay `to`, or back to the pending
+// list when to is nil.
+func (b *Builder) MoveHighlight(startTime uint64, to *uint32) error {
+	var err error
+
+	st := b.Store.Update("highlight_moved", func(cur domain.State) domain.State {
+		if to != nil {
+			if _, ok := cur.ReplayState.Replays[*to]; !ok {
+				err = ErrReplayNotFound
+				return cur
+			}
+		}
+
+		next, hl := takeHighlight(cur, startTime)
+		if hl == nil {
+			err = ErrHighlightNotFound
+			return cur
+		}
+
+		if to == nil {
+			next.ReplayState.PendingHighlights = append(next.ReplayState.PendingHighlights, hl)
+			return next
+		}
+
+		next.ReplayState.Replays = copyReplays(next.ReplayState.Replays)
+		replay := next.ReplayState.Replays[*to]
+		replay.Highlights = append(replay.Highlights, hl)
+		sort.Slice(replay.Highlights, func(i, j int) bool { return replay.Highlights[i].StartTime < replay.Highlights[j].StartTime })
+		next.ReplayState.Replays[*to] = replay
+		return next
+	})
+
+	if err != nil {
+		return err
+	}
+	b.changed(st)
+	return nil
+}
+
+func takeHighlight(cur domain.State, startTime uint64) (domain.State, *domain.Highlight) {
+	if i := indexHighlight(cur.ReplayState.PendingHighlights, startTime); i >= 0 {
+		hl := cur.ReplayState.PendingHighlights[i]
+		cur.ReplayState.PendingHighlights = removeHighlight(cur.ReplayState.PendingHighlights, i)
+		return cur, hl
+	}
+
+	for id, replay := range cur.ReplayState.Replays {
+		if i := indexHighlight(replay.Highlights, startTime); i >= 0 {
+			hl := replay.Highlights[i]
+			replay.Highlights = removeHighlight(replay.Highlights, i)
+			cur.ReplayState.Replays = copyReplays(cur.ReplayState.Replays)
+			cur.ReplayState.Replays[id] = replay
+			return cur, hl
+		}
+	}
+
+	return cur, nil
+}
+
+func findHighlight(st domain.State, startTime uint64) *domain.Highlight {
+	if i := indexHighlight(st.ReplayState.PendingHighlights, startTime); i >= 0 {
+		return st.ReplayState.PendingHighlights[i]
+	}
+	for _, replay := range st.ReplayState.Replays {
+		if i := indexHighlight(replay.Highlights, startTime); i >= 0 {
+			return replay.Highlights[i]
+		}
+	}
+	return nil
+}
+
+// copyReplays copies the replays map and their highlight lists before an
+// update changes them: the state's map is shared with readers of the store.
+func copyReplays(replays map[uint32]domain.Replay) map[uint32]domain.Replay {
+	out := make(map[uint32]domain.Replay, len(replays)+1)
+	for id, r := range replays {
+		r.Highlights = append([]*domain.Highlight(nil), r.Highlights...)
+		out[id] = r
+	}
+	return out
+}
+
+func indexHighlight(hls []*domain.Highlight, startTime uint64) int {
+	for i, hl := range hls {
+		if hl != nil && hl.StartTime == startTime {
+			return i
+		}
+	}
+	return -1
+}
 
-	return createdID, u.String(), nil
+func removeHighlight(hls []*domain.Highlight, i int) []*domain.Highlight {
+	out := make([]*domain.Highlight, 0, len(hls)-1)
+	out = append(out, hls[:i]...)
+	return append(out, hls[i+1:]...)
 }
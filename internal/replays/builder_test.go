@@ -0,0 +1,78 @@
+package replays
+
+import (
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/store"
+)
+
+func newTestBuilder(st domain.State) *Builder {
+	base, _ := url.Parse("http://replays.test")
+	return &Builder{Store: store.NewStateStore(st), BaseURL: base}
+}
+
+// Readers range over the state they got from the store without a lock, so
+// no update may change a map or slice they can still see.
+func TestBuilderLeavesEarlierStatesAlone(t *testing.T) {
+	b := newTestBuilder(domain.State{
+		ReplayState: domain.ReplayState{
+			CurrentReplayId:   3,
+			PendingHighlights: []*domain.Highlight{{StartTime: 500}},
+			Replays: map[uint32]domain.Replay{
+				1: {Highlights: []*domain.Highlight{{StartTime: 100}, {StartTime: 300}}},
+				2: {Highlights: []*domain.Highlight{{StartTime: 200}}},
+			},
+		},
+	})
+
+	before := b.Store.Get()
+	want := before.Clone()
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, r := range b.Store.Get().ReplayState.Replays {
+				for _, hl := range r.Highlights {
+					_ = hl
+				}
+			}
+		}
+	}()
+
+	one, two := uint32(1), uint32(2)
+	steps := []func() error{
+		func() error { return b.MoveHighlight(200, &one) },
+		func() error { return b.MoveHighlight(100, &two) },
+		func() error { return b.DeleteHighlight(300) },
+		func() error { _, _, err := b.CreateReplay(); return err },
+		func() error { return b.DeleteReplay(1) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if !reflect.DeepEqual(before, want) {
+		t.Errorf("updates changed an earlier state:\n%+v\nwant %+v", before.ReplayState, want.ReplayState)
+	}
+
+	rs := b.Store.Get().ReplayState
+	if _, ok := rs.Replays[1]; ok || len(rs.Replays[2].Highlights) != 1 || len(rs.Replays[3].Highlights) != 1 {
+		t.Errorf("replays after the updates = %+v", rs.Replays)
+	}
+}
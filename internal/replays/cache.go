@@ -0,0 +1,530 @@
+package replays
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheFileExt = ".ts"
+	cacheHLSExt  = ".hls"
+	cachePartExt = ".part" // render in progress, or left over by a crash
+)
+
+// renderJob is one render of a cache entry. Each attempt at it, e.g. a
+// retry with the fallback encoder, writes its own part file, so streams
+// following the first attempt never see the second one rewrite it.
+type renderJob struct {
+	done chan struct{} // closed once the entry is in place or the render failed
+	err  error
+
+	mu        sync.Mutex
+	cond      *sync.Cond // signalled when a follower closes
+	part      string     // the current attempt's file or directory
+	parts     []string
+	switched  chan struct{} // closed when the next attempt starts
+	rendered  chan struct{} // closed when render returns
+	renderErr error
+	followers int
+}
+
+// PartFunc returns a fresh temporary file (or directory, for HLS) for the
+// next attempt at rendering an entry. A render that retries calls it again
+// instead of rewriting the file it was given before.
+type PartFunc func() (string, error)
+
+// Cache keeps rendered replays on disk so repeated requests for the same
+// replay and plan are served from a file instead of re-encoding.
+type Cache struct {
+	Dir      string
+	MaxBytes int64         // 0 = unlimited
+	MaxAge   time.Duration // 0 = unlimited
+
+	mu       sync.Mutex
+	inflight map[string]*renderJob
+}
+
+func NewCache(dir string, maxBytes int64, maxAge time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Cache{
+		Dir:      dir,
+		MaxBytes: maxBytes,
+		MaxAge:   maxAge,
+		inflight: make(map[string]*renderJob),
+	}, nil
+}
+
+type planKey struct {
+	Clips    []Clip  `json:"clips"`
+	AudioIdx []int   `json:"audioIdx"`
+	FadeSec  float64 `json:"fadeSec"`
+	Profile  string  `json:"profile"`
+}
+
+// CacheKey identifies a rendered replay by its ID and a hash of its plan.
+func CacheKey(replayID uint32, clips []Clip, audioIdx []int, fade time.Duration, profile EncoderProfile) string {
+	b, _ := json.Marshal(planKey{
+		Clips:    clips,
+		AudioIdx: audioIdx,
+		FadeSec:  fade.Seconds(),
+		Profile:  profile.Name,
+	})
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf("%d-%s", replayID, hex.EncodeToString(sum[:8]))
+}
+
+func (c *Cache) Path(key string) string {
+	return filepath.Join(c.Dir, key+cacheFileExt)
+}
+
+func (c *Cache) HLSDir(key string) string {
+	return filepath.Join(c.Dir, key+cacheHLSExt)
+}
+
+// GetOrRender returns the path of the cached file for key, calling render
+// to produce it if needed. Concurrent callers for the same key share one render.
+func (c *Cache) GetOrRender(key string, render func(next PartFunc) error) (string, error) {
+	return c.getOrRender(c.Path(key), createPart, render)
+}
+
+// GetOrRenderHLS is like GetOrRender, but the entry is a directory holding
+// an HLS playlist and its segments. Each part is an existing empty directory.
+func (c *Cache) GetOrRenderHLS(key string, render func(next PartFunc) error) (string, error) {
+	return c.getOrRender(c.HLSDir(key), func(tmpDir string) error {
+		return os.MkdirAll(tmpDir, 0o755)
+	}, render)
+}
+
+// createPart creates the part file up front, so that it can be followed
+// before the encoder gets to it.
+func createPart(tmpPath string) error {
+	return os.WriteFile(tmpPath, nil, 0o644)
+}
+
+func (c *Cache) getOrRender(path string, prepare func(string) error, render func(PartFunc) error) (string, error) {
+	if job := c.start(path, prepare, render); job != nil {
+		<-job.done
+		return path, job.err
+	}
+	return path, nil
+}
+
+// start returns the in-flight render of path, starting one unless path is
+// already cached, in which case job is nil. The render runs on its own, so
+// it completes and fills the cache even if the caller goes away.
+func (c *Cache) start(path string, prepare func(string) error, render func(PartFunc) error) *renderJob {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if job, ok := c.inflight[path]; ok {
+		return job
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+		return nil
+	}
+
+	if c.inflight == nil {
+		c.inflight = make(map[string]*renderJob)
+	}
+	job := &renderJob{
+		done:     make(chan struct{}),
+		switched: make(chan struct{}),
+		rendered: make(chan struct{}),
+	}
+	job.cond = sync.NewCond(&job.mu)
+	c.inflight[path] = job
+
+	next := func() (string, error) {
+		job.mu.Lock()
+		tmpPath := fmt.Sprintf("%s.%d%s", path, len(job.parts)+1, cachePartExt)
+		job.mu.Unlock()
+
+		_ = os.RemoveAll(tmpPath)
+		if err := prepare(tmpPath); err != nil {
+			return "", err
+		}
+
+		job.mu.Lock()
+		job.part = tmpPath
+		job.parts = append(job.parts, tmpPath)
+		close(job.switched)
+		job.switched = make(chan struct{})
+		job.mu.Unlock()
+		return tmpPath, nil
+	}
+
+	go func() {
+		err := render(next)
+
+		job.mu.Lock()
+		job.renderErr = err
+		close(job.rendered)
+		// Windows cannot rename a file that is still open
+		for job.followers > 0 {
+			job.cond.Wait()
+		}
+		part, parts := job.part, job.parts
+		job.mu.Unlock()
+
+		if err == nil && part == "" {
+			err = errors.New("render produced no output")
+		}
+		if err == nil {
+			err = os.Rename(part, path)
+		}
+		for _, p := range parts {
+			if err != nil || p != part {
+				_ = os.RemoveAll(p)
+			}
+		}
+		job.err = err
+
+		c.mu.Lock()
+		delete(c.inflight, path)
+		c.mu.Unlock()
+		close(job.done)
+	}()
+
+	return job
+}
+
+// OpenOrRender is like GetOrRender, but does not wait for the render: while
+// it runs, the returned reader follows the growing file and ends when the
+// render does. When the entry is already cached, f is the finished file
+// instead and r is nil.
+func (c *Cache) OpenOrRender(ctx context.Context, key string, render func(next PartFunc) error) (r io.ReadCloser, f *os.File, err error) {
+	path := c.Path(key)
+
+	job := c.start(path, createPart, render)
+	if job != nil {
+		fr, err := job.follow(ctx)
+		if fr != nil || err != nil {
+			return fr, nil, err
+		}
+
+		// rendered already; wait for the file to be moved into place
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		if job.err != nil {
+			return nil, nil, job.err
+		}
+	}
+
+	f, err = os.Open(path)
+	return nil, f, err
+}
+
+// follow opens the current attempt's file once there is one. It returns
+// nil, nil when render has returned already.
+func (job *renderJob) follow(ctx context.Context) (*followReader, error) {
+	for {
+		job.mu.Lock()
+		select {
+		case <-job.rendered:
+			job.mu.Unlock()
+			return nil, nil
+		default:
+		}
+
+		if job.part != "" {
+			f, err := os.Open(job.part)
+			if err != nil {
+				job.mu.Unlock()
+				return nil, err
+			}
+			job.followers++
+			r := &followReader{ctx: ctx, job: job, f: f, part: job.part}
+			job.mu.Unlock()
+			return r, nil
+		}
+
+		switched := job.switched
+		job.mu.Unlock()
+
+		select {
+		case <-switched:
+		case <-job.rendered:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+const followPollInterval = 100 * time.Millisecond
+
+// errRenderRestarted ends a stream that had sent part of an attempt which
+// then failed: the retry's output cannot be spliced onto it.
+var errRenderRestarted = errors.New("render restarted after part of it was sent")
+
+// followReader reads a file that is still being written, like tail -f,
+// until its render job finishes. It moves on to a new attempt's file if it
+// has not returned anything of the failed one yet.
+type followReader struct {
+	ctx    context.Context
+	job    *renderJob
+	f      *os.File
+	part   string
+	sent   bool
+	closed bool
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		if !r.sent {
+			if err := r.switchPart(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.f.Read(p)
+		if n > 0 {
+			r.sent = true
+			return n, nil
+		}
+		if err != io.EOF {
+			return 0, err
+		}
+
+		job := r.job
+		job.mu.Lock()
+		part, switched := job.part, job.switched
+		rendered := false
+		select {
+		case <-job.rendered:
+			rendered = true
+		default:
+		}
+		renderErr := job.renderErr
+		job.mu.Unlock()
+
+		if part != r.part {
+			if r.sent {
+				return 0, errRenderRestarted
+			}
+			continue
+		}
+
+		if rendered {
+			if renderErr != nil {
+				return 0, renderErr
+			}
+			// the file is complete; read what was written since the last try
+			return r.f.Read(p)
+		}
+
+		select {
+		case <-switched:
+		case <-job.rendered:
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		case <-time.After(followPollInterval):
+		}
+	}
+}
+
+// switchPart moves on to the current attempt's file if a new one started.
+func (r *followReader) switchPart() error {
+	r.job.mu.Lock()
+	part := r.job.part
+	r.job.mu.Unlock()
+	if part == r.part {
+		return nil
+	}
+
+	f, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	_ = r.f.Close()
+	r.f, r.part = f, part
+	return nil
+}
+
+// Close lets the job move the finished file into place once every follower
+// has closed.
+func (r *followReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err := r.f.Close()
+
+	r.job.mu.Lock()
+	r.job.followers--
+	r.job.cond.Broadcast()
+	r.job.mu.Unlock()
+	return err
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Evict removes entries older than MaxAge, then the least recently used
+// entries until the cache fits into MaxBytes.
+func (c *Cache) Evict() (int, error) {
+	dirEntries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := c.removeStaleParts(dirEntries)
+
+	var entries []cacheEntry
+	var total int64
+	for _, de := range dirEntries {
+		if !isCacheEntry(de) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		e := cacheEntry{
+			path:    filepath.Join(c.Dir, de.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+		if de.IsDir() {
+			e.size = dirSize(e.path)
+		}
+		entries = append(entries, e)
+		total += e.size
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+
+	now := time.Now()
+	for _, e := range entries {
+		expired := c.MaxAge > 0 && now.Sub(e.modTime) > c.MaxAge
+		oversize := c.MaxBytes > 0 && total > c.MaxBytes
+		if !expired && !oversize {
+			continue
+		}
+		if err := os.RemoveAll(e.path); err != nil {
+			log.Printf("[ReplayCache] remove %s: %v", e.path, err)
+			continue
+		}
+		total -= e.size
+		removed++
+	}
+
+	return removed, nil
+}
+
+// Purge removes every cached replay.
+func (c *Cache) Purge() (int, error) {
+	dirEntries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := c.removeStaleParts(dirEntries)
+	for _, de := range dirEntries {
+		if !isCacheEntry(de) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.Dir, de.Name())); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeStaleParts removes partial renders that no render is writing to,
+// i.e. ones left behind when the server was killed mid-render.
+func (c *Cache) removeStaleParts(dirEntries []os.DirEntry) int {
+	removed := 0
+	for _, de := range dirEntries {
+		name, ok := strings.CutSuffix(de.Name(), cachePartExt)
+		if !ok {
+			continue
+		}
+		// parts are named <entry>.<attempt>.part
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			if _, err := strconv.Atoi(name[i+1:]); err == nil {
+				name = name[:i]
+			}
+		}
+		path := filepath.Join(c.Dir, name)
+		part := filepath.Join(c.Dir, de.Name())
+
+		// under the lock, so that no render of path can start meanwhile
+		c.mu.Lock()
+		_, rendering := c.inflight[path]
+		var err error
+		if !rendering {
+			err = os.RemoveAll(part)
+		}
+		c.mu.Unlock()
+
+		if err != nil {
+			log.Printf("[ReplayCache] remove %s: %v", part, err)
+		} else if !rendering {
+			removed++
+		}
+	}
+	return removed
+}
+
+func isCacheEntry(de os.DirEntry) bool {
+	if de.IsDir() {
+		return strings.HasSuffix(de.Name(), cacheHLSExt)
+	}
+	return strings.HasSuffix(de.Name(), cacheFileExt)
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func (c *Cache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := c.Evict(); err != nil {
+				log.Printf("[ReplayCache] evict failed: %v", err)
+			} else if n > 0 {
+				log.Printf("[ReplayCache] evicted %d file(s)", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package replays
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T) *Cache {
+	t.Helper()
+
+	c, err := NewCache(filepath.Join(t.TempDir(), "cache"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// writePart renders content into a fresh part.
+func writePart(next PartFunc, content string) error {
+	tmpPath, err := next()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tmpPath, []byte(content), 0o644)
+}
+
+func appendPart(t *testing.T, path, content string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Error(err)
+	}
+}
+
+func cacheFiles(t *testing.T, c *Cache) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, de := range entries {
+		names = append(names, de.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCacheGetOrRenderShared(t *testing.T) {
+	c := newTestCache(t)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	render := func(next PartFunc) error {
+		calls.Add(1)
+		<-release
+		return writePart(next, "replay")
+	}
+
+	var wg sync.WaitGroup
+	paths := make([]string, 5)
+	errs := make([]error, 5)
+	for i := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			paths[i], errs[i] = c.GetOrRender("1-a", render)
+		}()
+	}
+	// let every caller find the render in flight before it finishes
+	for {
+		c.mu.Lock()
+		_, started := c.inflight[c.Path("1-a")]
+		c.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range paths {
+		if errs[i] != nil || paths[i] != c.Path("1-a") {
+			t.Errorf("caller %d: %q, %v", i, paths[i], errs[i])
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("rendered %d times, want once", n)
+	}
+
+	// cached now
+	if _, err := c.GetOrRender("1-a", render); err != nil || calls.Load() != 1 {
+		t.Errorf("second GetOrRender rendered again (%d) or failed: %v", calls.Load(), err)
+	}
+	if got := readFile(t, c.Path("1-a")); got != "replay" {
+		t.Errorf("entry = %q", got)
+	}
+	if files := cacheFiles(t, c); len(files) != 1 {
+		t.Errorf("cache holds %v, want only the entry", files)
+	}
+}
+
+func TestCacheRenderError(t *testing.T) {
+	c := newTestCache(t)
+	failed := errors.New("encoder failed")
+
+	_, err := c.GetOrRender("1-a", func(next PartFunc) error {
+		if err := writePart(next, "half"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("err = %v, want the render's", err)
+	}
+	if files := cacheFiles(t, c); len(files) != 0 {
+		t.Errorf("a failed render left %v", files)
+	}
+
+	// nothing is cached; the next request renders again
+	if _, err := c.GetOrRender("1-a", func(next PartFunc) error { return writePart(next, "ok") }); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, c.Path("1-a")); got != "ok" {
+		t.Errorf("entry = %q", got)
+	}
+
+	if _, err := c.GetOrRender("1-b", func(next PartFunc) error { return nil }); err == nil {
+		t.Error("a render that asked for no part succeeded")
+	}
+}
+
+// followStep feeds a render one step at a time, so a test can check what a
+// follower sees in between.
+type followStep struct {
+	steps chan func(next PartFunc) error
+	done  chan struct{}
+}
+
+func newFollowStep() *followStep {
+	return &followStep{
+		steps: make(chan func(next PartFunc) error),
+		done:  make(chan struct{}),
+	}
+}
+
+func (s *followStep) render(next PartFunc) error {
+	for step := range s.steps {
+		err := step(next)
+		s.done <- struct{}{}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// do runs step in the render and waits for it.
+func (s *followStep) do(step func(next PartFunc) error) {
+	s.steps <- step
+	<-s.done
+}
+
+// waitIdle waits for the render of key to clean up after itself.
+func waitIdle(t *testing.T, c *Cache, key string) {
+	t.Helper()
+
+	for range 500 {
+		c.mu.Lock()
+		_, rendering := c.inflight[c.Path(key)]
+		c.mu.Unlock()
+		if !rendering {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("render of %s still in flight", key)
+}
+
+func readN(t *testing.T, r io.Reader, n int) string {
+	t.Helper()
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(buf)
+}
+
+func TestCacheOpenOrRenderFollows(t *testing.T) {
+	c := newTestCache(t)
+	steps := newFollowStep()
+
+	var part string
+	go steps.do(func(next PartFunc) error {
+		var err error
+		part, err = next()
+		return err
+	})
+
+	r, f, err := c.OpenOrRender(context.Background(), "1-a", steps.render)
+	if err != nil || r == nil || f != nil {
+		t.Fatalf("OpenOrRender = %v, %v, %v; want a follower", r, f, err)
+	}
+
+	steps.do(func(PartFunc) error { appendPart(t, part, "first "); return nil })
+	if got := readN(t, r, 6); got != "first " {
+		t.Errorf("read %q", got)
+	}
+	steps.do(func(PartFunc) error { appendPart(t, part, "second"); return nil })
+	close(steps.steps)
+
+	rest, err := io.ReadAll(r)
+	if err != nil || string(rest) != "second" {
+		t.Errorf("rest = %q, %v", rest, err)
+	}
+
+	// the part stays until the follower closes
+	if _, err := os.Stat(c.Path("1-a")); err == nil {
+		t.Error("entry moved into place while a follower still had the part open")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	path, err := c.GetOrRender("1-a", func(PartFunc) error {
+		t.Error("rendered again")
+		return nil
+	})
+	if err != nil || readFile(t, path) != "first second" {
+		t.Errorf("entry = %q, %v", readFile(t, path), err)
+	}
+
+	// cached: the finished file instead of a follower
+	r, f, err = c.OpenOrRender(context.Background(), "1-a", steps.render)
+	if err != nil || r != nil || f == nil {
+		t.Fatalf("cached OpenOrRender = %v, %v, %v; want the file", r, f, err)
+	}
+	f.Close()
+}
+
+func TestCacheOpenOrRenderFallback(t *testing.T) {
+	c := newTestCache(t)
+	steps := newFollowStep()
+
+	var first string
+	go steps.do(func(next PartFunc) error {
+		var err error
+		first, err = next()
+		return err
+	})
+	started, _, err := c.OpenOrRender(context.Background(), "1-a", steps.render)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer started.Close()
+	waiting, _, err := c.OpenOrRender(context.Background(), "1-a", steps.render)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waiting.Close()
+
+	steps.do(func(PartFunc) error { appendPart(t, first, "AAA"); return nil })
+	if got := readN(t, started, 3); got != "AAA" {
+		t.Fatalf("read %q", got)
+	}
+
+	// the hardware encoder gives up and the fallback renders into a new part
+	var second string
+	steps.do(func(next PartFunc) error {
+		var err error
+		second, err = next()
+		return err
+	})
+	if second == first {
+		t.Fatalf("the retry got the first attempt's part %s", first)
+	}
+	if got := readFile(t, first); got != "AAA" {
+		t.Errorf("first part rewritten to %q", got)
+	}
+
+	late, _, err := c.OpenOrRender(context.Background(), "1-a", steps.render)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+
+	steps.do(func(PartFunc) error { appendPart(t, second, "BBBB"); return nil })
+	close(steps.steps)
+
+	if _, err := started.Read(make([]byte, 8)); !errors.Is(err, errRenderRestarted) {
+		t.Errorf("follower that sent the failed attempt: err = %v, want errRenderRestarted", err)
+	}
+	for name, r := range map[string]io.Reader{"waiting": waiting, "late": late} {
+		if b, err := io.ReadAll(r); err != nil || string(b) != "BBBB" {
+			t.Errorf("%s follower read %q, %v; want the retry", name, b, err)
+		}
+	}
+}
+
+func TestCacheOpenOrRenderError(t *testing.T) {
+	c := newTestCache(t)
+	steps := newFollowStep()
+	failed := errors.New("encoder failed")
+
+	var part string
+	go steps.do(func(next PartFunc) error {
+		var err error
+		part, err = next()
+		return err
+	})
+	r, _, err := c.OpenOrRender(context.Background(), "1-a", steps.render)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps.do(func(PartFunc) error { appendPart(t, part, "half"); return nil })
+	steps.do(func(PartFunc) error { return failed })
+
+	b, err := io.ReadAll(r)
+	if string(b) != "half" || !errors.Is(err, failed) {
+		t.Errorf("read %q, %v; want the render's error after what was written", b, err)
+	}
+	r.Close()
+	waitIdle(t, c, "1-a")
+
+	if _, err := c.GetOrRender("1-a", func(next PartFunc) error { return writePart(next, "ok") }); err != nil {
+		t.Fatal(err)
+	}
+	if files := cacheFiles(t, c); len(files) != 1 || files[0] != "1-a.ts" {
+		t.Errorf("cache holds %v, want only the new entry", files)
+	}
+}
+
+func TestCacheOpenOrRenderCancel(t *testing.T) {
+	c := newTestCache(t)
+	steps := newFollowStep()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go steps.do(func(next PartFunc) error { _, err := next(); return err })
+	r, _, err := c.OpenOrRender(ctx, "1-a", steps.render)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if _, err := r.Read(make([]byte, 8)); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	r.Close()
+
+	// the render goes on without the follower and fills the cache
+	close(steps.steps)
+	if _, err := c.GetOrRender("1-a", steps.render); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.Path("1-a")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCacheGetOrRenderHLS(t *testing.T) {
+	c := newTestCache(t)
+
+	dir, err := c.GetOrRenderHLS("1-a", func(next PartFunc) error {
+		tmpDir, err := next()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(tmpDir, hlsPlaylistName), []byte("#EXTM3U"), 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != c.HLSDir("1-a") || readFile(t, filepath.Join(dir, hlsPlaylistName)) != "#EXTM3U" {
+		t.Errorf("playlist not in %s", dir)
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	c := newTestCache(t)
+	c.MaxBytes = 10
+	c.MaxAge = time.Hour
+
+	now := time.Now()
+	entries := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"1-old.ts", 1, 2 * time.Hour}, // expired
+		{"2-lru.ts", 4, 30 * time.Minute},
+		{"3-mid.ts", 4, 20 * time.Minute},
+		{"4-new.ts", 4, 10 * time.Minute},
+		{"notes.txt", 100, 3 * time.Hour}, // not an entry
+		{"5-crash.ts.1.part", 100, 0},     // left over by a crash
+		{"6-old-format.ts.part", 100, 0},
+	}
+	for _, e := range entries {
+		path := filepath.Join(c.Dir, e.name)
+		if err := os.WriteFile(path, make([]byte, e.size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		at := now.Add(-e.age)
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a render in progress keeps its part
+	c.inflight[c.Path("7-rendering")] = &renderJob{}
+	if err := os.WriteFile(c.Path("7-rendering")+".1"+cachePartExt, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := c.Evict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 4 {
+		t.Errorf("removed %d, want 4", removed)
+	}
+	want := []string{"3-mid.ts", "4-new.ts", "7-rendering.ts.1.part", "notes.txt"}
+	if got := cacheFiles(t, c); !slices.Equal(got, want) {
+		t.Errorf("left %v, want %v", got, want)
+	}
+
+	removed, err = c.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("Purge removed %d, want 2", removed)
+	}
+	want = []string{"7-rendering.ts.1.part", "notes.txt"}
+	if got := cacheFiles(t, c); !slices.Equal(got, want) {
+		t.Errorf("left %v, want %v", got, want)
+	}
+}
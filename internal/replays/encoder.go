@@ -0,0 +1,209 @@
+package replays
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	EncoderCPU   = "cpu"
+	EncoderNVENC = "nvenc"
+	EncoderVAAPI = "vaapi"
+	EncoderQSV   = "qsv"
+)
+
+type EncoderProfile struct {
+	Name     string
+	Codec    string
+	Hardware bool
+
+	Preset  string
+	Tune    string
+	Bitrate string // e.g. "25M"
+	MaxRate string
+	BufSize string
+	GOP     int
+
+	// VAAPI render node, e.g. /dev/dri/renderD128
+	Device string
+}
+
+var DefaultEncoderProfiles = map[string]EncoderProfile{
+	EncoderCPU: {
+		Name:    EncoderCPU,
+		Codec:   "libx264",
+		Preset:  "veryfast",
+		Tune:    "zerolatency",
+		Bitrate: "12M",
+		MaxRate: "12M",
+		BufSize: "6M",
+		GOP:     120,
+	},
+	EncoderNVENC: {
+		Name:     EncoderNVENC,
+		Codec:    "h264_nvenc",
+		Hardware: true,
+		Preset:   "p5",
+		Tune:     "ll",
+		Bitrate:  "25M",
+		MaxRate:  "25M",
+		BufSize:  "12M",
+		GOP:      120,
+	},
+	EncoderVAAPI: {
+		Name:     EncoderVAAPI,
+		Codec:    "h264_vaapi",
+		Hardware: true,
+		Bitrate:  "25M",
+		MaxRate:  "25M",
+		BufSize:  "12M",
+		GOP:      120,
+		Device:   "/dev/dri/renderD128",
+	},
+	EncoderQSV: {
+		Name:     EncoderQSV,
+		Codec:    "h264_qsv",
+		Hardware: true,
+		Preset:   "faster",
+		Bitrate:  "25M",
+		MaxRate:  "25M",
+		BufSize:  "12M",
+		GOP:      120,
+	},
+}
+
+// EncoderProfileNames returns the names of all known profiles, sorted.
+func EncoderProfileNames(overrides map[string]EncoderProfile) []string {
+	seen := make(map[string]struct{}, len(DefaultEncoderProfiles)+len(overrides))
+	for name := range DefaultEncoderProfiles {
+		seen[name] = struct{}{}
+	}
+	for name := range overrides {
+		seen[name] = struct{}{}
+	}
+
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// LookupEncoderProfile finds a profile by name. An override of a built-in
+// profile only changes the fields it sets, e.g. nvenc: {bitrate: 40M}.
+func LookupEncoderProfile(name string, overrides map[string]EncoderProfile) (EncoderProfile, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = EncoderCPU
+	}
+
+	p, builtin := DefaultEncoderProfiles[name]
+	o, overridden := overrides[name]
+	if !builtin && !overridden {
+		return EncoderProfile{}, fmt.Errorf("unknown encoder profile %q", name)
+	}
+	if overridden {
+		p = p.merge(o)
+	}
+	if p.Name == "" {
+		p.Name = name
+	}
+	if p.Codec == "" {
+		return EncoderProfile{}, fmt.Errorf("encoder profile %q has no codec", name)
+	}
+
+	return p, nil
+}
+
+// merge applies the fields set in o. Hardware goes with the codec: it is
+// taken from o only when o sets the codec, or turns it on.
+func (p EncoderProfile) merge(o EncoderProfile) EncoderProfile {
+	if o.Name != "" {
+		p.Name = o.Name
+	}
+	if o.Codec != "" {
+		p.Codec = o.Codec
+		p.Hardware = o.Hardware
+	} else if o.Hardware {
+		p.Hardware = true
+	}
+	if o.Preset != "" {
+		p.Preset = o.Preset
+	}
+	if o.Tune != "" {
+		p.Tune = o.Tune
+	}
+	if o.Bitrate != "" {
+		p.Bitrate = o.Bitrate
+	}
+	if o.MaxRate != "" {
+		p.MaxRate = o.MaxRate
+	}
+	if o.BufSize != "" {
+		p.BufSize = o.BufSize
+	}
+	if o.Device != "" {
+		p.Device = o.Device
+	}
+	if o.GOP > 0 {
+		p.GOP = o.GOP
+	}
+	return p
+}
+
+// globalArgs go before the first input.
+func (p EncoderProfile) globalArgs() []string {
+	switch p.Codec {
+	case "h264_vaapi":
+		if p.Device != "" {
+			return []string{"-vaapi_device", p.Device}
+		}
+	case "h264_qsv":
+		return []string{"-init_hw_device", "qsv=hw", "-filter_hw_device", "hw"}
+	}
+	return nil
+}
+
+// uploadFilter is appended to the final video label of the filter graph.
+func (p EncoderProfile) uploadFilter() string {
+	switch p.Codec {
+	case "h264_vaapi":
+		return "format=nv12,hwupload"
+	case "h264_qsv":
+		return "format=nv12,hwupload=extra_hw_frames=64"
+	}
+	return ""
+}
+
+func (p EncoderProfile) videoArgs() []string {
+	args := []string{"-c:v", p.Codec}
+
+	if p.Preset != "" {
+		args = append(args, "-preset", p.Preset)
+	}
+	if p.Tune != "" {
+		args = append(args, "-tune", p.Tune)
+	}
+	if p.Codec == "h264_nvenc" {
+		args = append(args, "-rc", "cbr")
+	}
+	if p.Bitrate != "" {
+		args = append(args, "-b:v", p.Bitrate)
+	}
+	if p.MaxRate != "" {
+		args = append(args, "-maxrate", p.MaxRate)
+	}
+	if p.BufSize != "" {
+		args = append(args, "-bufsize", p.BufSize)
+	}
+	if p.GOP > 0 {
+		args = append(args, "-g", fmt.Sprintf("%d", p.GOP))
+	}
+	if p.Codec == "libx264" {
+		args = append(args, "-pix_fmt", "yuv420p")
+	}
+
+	return args
+}
@@ -0,0 +1,177 @@
+package replays
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookupEncoderProfile(t *testing.T) {
+	tests := []struct {
+		name      string
+		lookup    string
+		overrides map[string]EncoderProfile
+		want      EncoderProfile
+		err       string
+	}{
+		{name: "built-in", lookup: "NVENC", want: DefaultEncoderProfiles[EncoderNVENC]},
+		{name: "empty is cpu", lookup: " ", want: DefaultEncoderProfiles[EncoderCPU]},
+		{name: "unknown", lookup: "quicksync", err: `unknown encoder profile "quicksync"`},
+		{
+			name:      "partial override keeps the rest",
+			lookup:    EncoderNVENC,
+			overrides: map[string]EncoderProfile{EncoderNVENC: {Bitrate: "40M", GOP: 60}},
+			want: EncoderProfile{
+				Name: EncoderNVENC, Codec: "h264_nvenc", Hardware: true,
+				Preset: "p5", Tune: "ll", Bitrate: "40M", MaxRate: "25M", BufSize: "12M", GOP: 60,
+			},
+		},
+		{
+			name:      "new codec brings its own hardware flag",
+			lookup:    EncoderNVENC,
+			overrides: map[string]EncoderProfile{EncoderNVENC: {Codec: "libx265"}},
+			want: EncoderProfile{
+				Name: EncoderNVENC, Codec: "libx265",
+				Preset: "p5", Tune: "ll", Bitrate: "25M", MaxRate: "25M", BufSize: "12M", GOP: 120,
+			},
+		},
+		{
+			name:      "device override",
+			lookup:    EncoderVAAPI,
+			overrides: map[string]EncoderProfile{EncoderVAAPI: {Device: "/dev/dri/renderD129"}},
+			want: EncoderProfile{
+				Name: EncoderVAAPI, Codec: "h264_vaapi", Hardware: true,
+				Bitrate: "25M", MaxRate: "25M", BufSize: "12M", GOP: 120, Device: "/dev/dri/renderD129",
+			},
+		},
+		{
+			name:      "custom",
+			lookup:    "studio",
+			overrides: map[string]EncoderProfile{"studio": {Codec: "h264_nvenc", Hardware: true, Bitrate: "50M"}},
+			want:      EncoderProfile{Name: "studio", Codec: "h264_nvenc", Hardware: true, Bitrate: "50M"},
+		},
+		{
+			name:      "custom without a codec",
+			lookup:    "studio",
+			overrides: map[string]EncoderProfile{"studio": {Bitrate: "50M"}},
+			err:       `encoder profile "studio" has no codec`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LookupEncoderProfile(tt.lookup, tt.overrides)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoderVideoArgs(t *testing.T) {
+	tests := []struct {
+		profile string
+		global  []string
+		upload  string
+		video   string
+	}{
+		{
+			profile: EncoderCPU,
+			video:   "-c:v libx264 -preset veryfast -tune zerolatency -b:v 12M -maxrate 12M -bufsize 6M -g 120 -pix_fmt yuv420p",
+		},
+		{
+			profile: EncoderNVENC,
+			video:   "-c:v h264_nvenc -preset p5 -tune ll -rc cbr -b:v 25M -maxrate 25M -bufsize 12M -g 120",
+		},
+		{
+			profile: EncoderVAAPI,
+			global:  []string{"-vaapi_device", "/dev/dri/renderD128"},
+			upload:  "format=nv12,hwupload",
+			video:   "-c:v h264_vaapi -b:v 25M -maxrate 25M -bufsize 12M -g 120",
+		},
+		{
+			profile: EncoderQSV,
+			global:  []string{"-init_hw_device", "qsv=hw", "-filter_hw_device", "hw"},
+			upload:  "format=nv12,hwupload=extra_hw_frames=64",
+			video:   "-c:v h264_qsv -preset faster -b:v 25M -maxrate 25M -bufsize 12M -g 120",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			p := DefaultEncoderProfiles[tt.profile]
+			if got := p.globalArgs(); !reflect.DeepEqual(got, tt.global) {
+				t.Errorf("globalArgs = %q, want %q", got, tt.global)
+			}
+			if got := p.uploadFilter(); got != tt.upload {
+				t.Errorf("uploadFilter = %q, want %q", got, tt.upload)
+			}
+			if got := strings.Join(p.videoArgs(), " "); got != tt.video {
+				t.Errorf("videoArgs = %q\nwant %q", got, tt.video)
+			}
+		})
+	}
+}
+
+func TestBuildFFmpegArgs(t *testing.T) {
+	clips := []Clip{
+		{MediaPath: "a.mkv", StartSec: 1.5, DurSec: 4},
+		{MediaPath: "b.mkv", StartSec: 10, DurSec: 3},
+	}
+	output := []string{"-f", "mpegts", "out.ts"}
+
+	tests := []struct {
+		name    string
+		clips   []Clip
+		profile string
+		want    string
+	}{
+		{
+			name:    "one clip",
+			clips:   clips[:1],
+			profile: EncoderCPU,
+			want: "-thread_queue_size 4096 -ss 1.500 -i a.mkv " +
+				"-hide_banner -loglevel warning -filter_complex " +
+				"[0:v]trim=duration=4.000,setpts=PTS-STARTPTS,scale=in_range=pc:out_range=pc,format=yuv420p[v0];" +
+				"[0:a:2]atrim=duration=4.000,asetpts=PTS-STARTPTS,aformat=sample_rates=48000:channel_layouts=stereo,aresample=async=1000:first_pts=0[a0]; " +
+				"-map [v0] -map [a0] " +
+				"-c:v libx264 -preset veryfast -tune zerolatency -b:v 12M -maxrate 12M -bufsize 6M -g 120 -pix_fmt yuv420p " +
+				"-c:a aac -b:a 128k -f mpegts out.ts",
+		},
+		{
+			name:    "two clips on vaapi",
+			clips:   clips,
+			profile: EncoderVAAPI,
+			want: "-vaapi_device /dev/dri/renderD128 " +
+				"-thread_queue_size 4096 -ss 1.500 -i a.mkv -thread_queue_size 4096 -ss 10.000 -i b.mkv " +
+				"-hide_banner -loglevel warning -filter_complex " +
+				"[0:v]trim=duration=4.000,setpts=PTS-STARTPTS,scale=in_range=pc:out_range=pc,format=yuv420p[v0];" +
+				"[0:a:2]atrim=duration=4.000,asetpts=PTS-STARTPTS,aformat=sample_rates=48000:channel_layouts=stereo,aresample=async=1000:first_pts=0[a0];" +
+				"[1:v]trim=duration=3.000,setpts=PTS-STARTPTS,scale=in_range=pc:out_range=pc,format=yuv420p[v1];" +
+				"[1:a:0]atrim=duration=3.000,asetpts=PTS-STARTPTS,aformat=sample_rates=48000:channel_layouts=stereo,aresample=async=1000:first_pts=0[a1];" +
+				"[v0][v1]xfade=transition=fade:duration=0.500:offset=3.500[vxf1];" +
+				"[a0][a1]acrossfade=d=0.500:c1=tri:c2=tri[axf1];" +
+				"[vxf1]format=nv12,hwupload[vout]; " +
+				"-map [vout] -map [axf1] " +
+				"-c:v h264_vaapi -b:v 25M -maxrate 25M -bufsize 12M -g 120 " +
+				"-c:a aac -b:a 128k -f mpegts out.ts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the second clip has no game-only track
+			args := buildFFmpegArgs(tt.clips, []int{2, -1}, 500*time.Millisecond, DefaultEncoderProfiles[tt.profile], output...)
+			if got := strings.Join(args, " "); got != tt.want {
+				t.Errorf("args:\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package replays
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const hlsPlaylistName = "index.m3u8"
+
+var hlsSegmentRe = regexp.MustCompile(`^seg_\d{3,}\.ts$`)
+
+// hlsOutput renders a VOD playlist whose segment URIs are relative to
+// /replays/{id}/, i.e. "{key}/seg_000.ts".
+func hlsOutput(dir string, key string) []string {
+	return []string{
+		"-f", "hls",
+		"-hls_time", "2",
+		"-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_filename", filepath.Join(dir, "seg_%03d.ts"),
+		"-hls_base_url", key + "/",
+		filepath.Join(dir, hlsPlaylistName),
+	}
+}
+
+func (s *Streamer) HandleHLSPlaylist(w http.ResponseWriter, r *http.Request) {
+	if s.Cache == nil {
+		http.Error(w, "HLS requires the replay cache", http.StatusNotFound)
+		return
+	}
+
+	plan, status, err := s.planRequest(r, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	key := plan.cacheKey()
+	dir, err := s.Cache.GetOrRenderHLS(key, func(next PartFunc) error {
+		return s.renderWithFallback(plan, next, func(tmpDir string) []string {
+			return hlsOutput(tmpDir, key)
+		})
+	})
+	if err != nil {
+		http.Error(w, "ffmpeg render error", http.StatusInternalServerError)
+		log.Printf("ffmpeg HLS render error: %v", err)
+		return
+	}
+
+	if plan.controlObs {
+		s.ObsController.SetCurrentReplay(plan.replayID, plan.totalDur)
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, filepath.Join(dir, hlsPlaylistName))
+}
+
+func (s *Streamer) HandleHLSSegment(w http.ResponseWriter, r *http.Request) {
+	if s.Cache == nil {
+		http.Error(w, "HLS requires the replay cache", http.StatusNotFound)
+		return
+	}
+
+	id := r.PathValue("id")
+	key := r.PathValue("key")
+	segment := r.PathValue("segment")
+
+	if !strings.HasPrefix(key, id+"-") || strings.ContainsAny(key, `/\.`) || !hlsSegmentRe.MatchString(segment) {
+		http.Error(w, "segment not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/MP2T")
+	http.ServeFile(w, r, filepath.Join(s.Cache.HLSDir(key), segment))
+}
@@ -6,9 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,28 +30,51 @@ type Streamer struct {
 	FFmpegBin            string
 	FFprobeBin           string
 
+	// EncoderProfile is used when the request has no profile parameter.
+	// FallbackProfile is tried when a hardware encoder fails to start.
+	EncoderProfile  string
+	FallbackProfile string
+	Encoders        map[string]EncoderProfile
+
+	// Cache, when set, renders each replay plan once to disk and serves
+	// subsequent requests from the file.
+	Cache *Cache
+
 	audioMu    sync.Mutex
 	audioCache map[string]int // MediaPath -> audioIdx (a:<idx>)
 }
 
-func (s *Streamer) HandleStream(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("replay_id")
+const replayFade = 350 * time.Millisecond
+
+type replayPlan struct {
+	replayID   uint32
+	clips      []Clip
+	totalDur   time.Duration
+	audioIdx   []int
+	profile    EncoderProfile
+	controlObs bool
+}
+
+func (p *replayPlan) cacheKey() string {
+	return CacheKey(p.replayID, p.clips, p.audioIdx, replayFade, p.profile)
+}
+
+// planRequest builds the clip plan for a replay from the common query
+// parameters: max_duration, control_obs and profile.
+func (s *Streamer) planRequest(r *http.Request, idStr string) (*replayPlan, int, error) {
 	if idStr == "" {
-		http.Error(w, "missing replay_id", http.StatusBadRequest)
-		return
+		return nil, http.StatusBadRequest, errors.New("missing replay_id")
 	}
 	id64, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		http.Error(w, "invalid replay_id", http.StatusBadRequest)
-		return
+		return nil, http.StatusBadRequest, errors.New("invalid replay_id")
 	}
 	replayID := uint32(id64)
 
 	st := s.Store.Get()
 	replay, ok := st.ReplayState.Replays[replayID]
 	if !ok {
-		http.Error(w, "replay not found", http.StatusNotFound)
-		return
+		return nil, http.StatusNotFound, errors.New("replay not found")
 	}
 
 	highlights := replay.Highlights
@@ -58,7 +84,7 @@ func (s *Streamer) HandleStream(w http.ResponseWriter, r *http.Request) {
 	if maxDurStr != "" {
 		maxDur, err := strconv.ParseUint(maxDurStr, 10, 64)
 		if err != nil {
-			http.Error(w, "invalid max_duration", http.StatusBadRequest)
+			return nil, http.StatusBadRequest, errors.New("invalid max_duration")
 		}
 
 		streamDuration = time.Duration(maxDur) * time.Second
@@ -71,42 +97,318 @@ func (s *Streamer) HandleStream(w http.ResponseWriter, r *http.Request) {
 	if controlObsStr != "" {
 		controlObs, err = strconv.ParseBool(controlObsStr)
 		if err != nil {
-			http.Error(w, "invalid control_obs", http.StatusBadRequest)
+			return nil, http.StatusBadRequest, errors.New("invalid control_obs")
 		}
-	} else {
-		controlObs = false
 	}
 
-	const fade = 350 * time.Millisecond
+	profileName := r.URL.Query().Get("profile")
+	if profileName == "" {
+		profileName = s.EncoderProfile
+	}
+	profile, err := LookupEncoderProfile(profileName, s.Encoders)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
 
-	clips, totalDur, err := BuildPlan(streamDuration, highlights, fade)
+	clips, totalDur, err := BuildPlan(streamDuration, highlights, replayFade)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, http.StatusBadRequest, err
+	}
+
+	return &replayPlan{
+		replayID:   replayID,
+		clips:      clips,
+		totalDur:   totalDur,
+		audioIdx:   s.resolveAudioIndices(clips),
+		profile:    profile,
+		controlObs: controlObs,
+	}, http.StatusOK, nil
+}
+
+type planClipView struct {
+	MediaPath string  `json:"mediaPath"`
+	StartSec  float64 `json:"startSec"`
+	DurSec    float64 `json:"durSec"`
+	SortKeyMs uint64  `json:"sortKeyMs"`
+	AudioIdx  int     `json:"audioIdx"`
+}
+
+type planView struct {
+	ReplayID        uint32         `json:"replayId"`
+	Profile         string         `json:"profile"`
+	TotalDurationMs int64          `json:"totalDurationMs"`
+	FadeMs          int64          `json:"fadeMs"`
+	CacheKey        string         `json:"cacheKey"`
+	Clips           []planClipView `json:"clips"`
+}
+
+// HandlePlan returns the clip list BuildPlan produces for a replay, taking
+// the same query parameters as the stream.
+func (s *Streamer) HandlePlan(w http.ResponseWriter, r *http.Request) {
+	plan, status, err := s.planRequest(r, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	audioIdx := s.resolveAudioIndices(clips)
-	args := buildFFmpegArgsNVENC(clips, audioIdx, fade)
+	view := planView{
+		ReplayID:        plan.replayID,
+		Profile:         plan.profile.Name,
+		TotalDurationMs: plan.totalDur.Milliseconds(),
+		FadeMs:          replayFade.Milliseconds(),
+		CacheKey:        plan.cacheKey(),
+		Clips:           make([]planClipView, 0, len(plan.clips)),
+	}
+	for i, c := range plan.clips {
+		view.Clips = append(view.Clips, planClipView{
+			MediaPath: c.MediaPath,
+			StartSec:  c.StartSec,
+			DurSec:    c.DurSec,
+			SortKeyMs: c.SortKeyMs,
+			AudioIdx:  plan.audioIdx[i],
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(view)
+}
+
+func (s *Streamer) HandleStream(w http.ResponseWriter, r *http.Request) {
+	plan, status, err := s.planRequest(r, r.URL.Query().Get("replay_id"))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if s.Cache != nil {
+		s.serveCached(w, r, plan)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	proc, err := s.startFFmpeg(ctx, buildFFmpegArgs(plan.clips, plan.audioIdx, replayFade, plan.profile, pipeOutput...))
+	if fallback, ok := s.fallbackFor(plan.profile, err); ok {
+		proc, err = s.startFFmpeg(ctx, buildFFmpegArgs(plan.clips, plan.audioIdx, replayFade, fallback, pipeOutput...))
+	}
+	if err != nil {
+		http.Error(w, "ffmpeg start error", http.StatusInternalServerError)
+		log.Printf("ffmpeg start error: %v", err)
+		return
+	}
+	defer proc.stop()
 
 	w.Header().Set("Content-Type", "video/MP2T")
 	w.Header().Set("Cache-Control", "no-store")
 
 	flusher, _ := w.(http.Flusher)
 
-	ctx, cancel := context.WithCancel(r.Context())
+	if plan.controlObs {
+		s.ObsController.SetCurrentReplay(plan.replayID, plan.totalDur)
+	}
+
+	if _, err := w.Write(proc.first); err != nil {
+		return
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := proc.stdout.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				cancel()
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr != nil {
+			return
+		}
+	}
+}
+
+// fallbackFor reports whether a failed hardware encoder should be retried
+// with the fallback profile.
+func (s *Streamer) fallbackFor(profile EncoderProfile, err error) (EncoderProfile, bool) {
+	if err == nil || !profile.Hardware {
+		return EncoderProfile{}, false
+	}
+	fallback, lookupErr := LookupEncoderProfile(s.FallbackProfile, s.Encoders)
+	if lookupErr != nil || fallback.Name == profile.Name {
+		return EncoderProfile{}, false
+	}
+	log.Printf("encoder %q failed to start (%v), falling back to %q", profile.Name, err, fallback.Name)
+	return fallback, true
+}
+
+func (s *Streamer) serveCached(w http.ResponseWriter, r *http.Request, plan *replayPlan) {
+	render := func(next PartFunc) error {
+		return s.renderWithFallback(plan, next, func(tmpPath string) []string {
+			return []string{"-y", "-f", "mpegts", tmpPath}
+		})
+	}
+	initial := r.Header.Get("Range") == ""
+
+	var live io.ReadCloser
+	var f *os.File
+	var err error
+	if initial {
+		// stream the replay while it renders instead of after
+		live, f, err = s.Cache.OpenOrRender(r.Context(), plan.cacheKey(), render)
+	} else {
+		// ranges are served from the finished file
+		var path string
+		if path, err = s.Cache.GetOrRender(plan.cacheKey(), render); err == nil {
+			f, err = os.Open(path)
+		}
+	}
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, "ffmpeg render error", http.StatusInternalServerError)
+			log.Printf("ffmpeg render error: %v", err)
+		}
+		return
+	}
+
+	// only the initial request starts the OBS timer, not range requests for the tail
+	if plan.controlObs && initial {
+		s.ObsController.SetCurrentReplay(plan.replayID, plan.totalDur)
+	}
+
+	w.Header().Set("Content-Type", "video/MP2T")
+
+	if live != nil {
+		defer live.Close()
+		w.Header().Set("Cache-Control", "no-store")
+		if err := copyFlushing(w, live); err != nil && r.Context().Err() == nil {
+			log.Printf("ffmpeg render error: %v", err)
+		}
+		return
+	}
+
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "cached replay stat error", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, filepath.Base(f.Name()), info.ModTime(), f)
+}
+
+// copyFlushing copies src to w, flushing after every chunk so the player
+// gets the replay as it is produced.
+func copyFlushing(w http.ResponseWriter, src io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return nil
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// renderTimeout bounds one ffmpeg render to a file. Replays are a minute or
+// two long, so a render running this long is stuck on its input or encoder.
+const renderTimeout = 10 * time.Minute
+
+// renderWithFallback renders plan into a part from next, and into a fresh
+// one with the fallback encoder if the first attempt fails.
+func (s *Streamer) renderWithFallback(plan *replayPlan, next PartFunc, output func(tmpPath string) []string) error {
+	attempt := func(profile EncoderProfile) error {
+		tmpPath, err := next()
+		if err != nil {
+			return err
+		}
+		return s.render(buildFFmpegArgs(plan.clips, plan.audioIdx, replayFade, profile, output(tmpPath)...))
+	}
+
+	err := attempt(plan.profile)
+	if fallback, ok := s.fallbackFor(plan.profile, err); ok {
+		err = attempt(fallback)
+	}
+	return err
+}
+
+func (s *Streamer) render(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), renderTimeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, s.FFmpegBin, args...)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		log.Printf("[ffmpeg] %s", strings.TrimSpace(string(out)))
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("render timed out after %s: %w", renderTimeout, err)
+	}
+	return err
+}
 
-	stdout, err := cmd.StdoutPipe()
+func (s *Streamer) HandleCacheEvict(w http.ResponseWriter, r *http.Request) {
+	if s.Cache == nil {
+		http.Error(w, "replay cache is disabled", http.StatusNotFound)
+		return
+	}
+
+	var removed int
+	var err error
+	if all, _ := strconv.ParseBool(r.URL.Query().Get("all")); all {
+		removed, err = s.Cache.Purge()
+	} else {
+		removed, err = s.Cache.Evict()
+	}
 	if err != nil {
-		http.Error(w, "stdout pipe error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+}
+
+type ffmpegProc struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	first  []byte
+}
+
+func (p *ffmpegProc) stop() {
+	_ = p.cmd.Process.Kill()
+	_ = p.cmd.Wait()
+}
+
+// startFFmpeg starts ffmpeg and waits for the first chunk of output,
+// so that encoder initialization failures are reported as an error
+// before anything is written to the client.
+func (s *Streamer) startFFmpeg(ctx context.Context, args []string) (*ffmpegProc, error) {
+	cmd := exec.CommandContext(ctx, s.FFmpegBin, args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		http.Error(w, "stderr pipe error", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("stderr pipe: %w", err)
 	}
 
 	go func() {
@@ -123,40 +425,36 @@ func (s *Streamer) HandleStream(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	if err := cmd.Start(); err != nil {
-		http.Error(w, "ffmpeg start error", http.StatusInternalServerError)
-		log.Printf("ffmpeg start error: %v", err)
-		return
+		return nil, err
 	}
 
-	if controlObs {
-		s.ObsController.SetCurrentReplay(replayID, totalDur)
-	}
-
-	defer func() {
-		_ = cmd.Process.Kill()
-		_ = cmd.Wait()
-	}()
-
-	buf := make([]byte, 32*1024)
-	for {
-		n, readErr := stdout.Read(buf)
-		if n > 0 {
-			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
-				cancel()
-				return
-			}
-			if flusher != nil {
-				flusher.Flush()
-			}
+	first := make([]byte, 32*1024)
+	n, readErr := stdout.Read(first)
+	if n == 0 {
+		waitErr := cmd.Wait()
+		if waitErr != nil {
+			return nil, waitErr
 		}
 		if readErr != nil {
-			return
+			return nil, fmt.Errorf("no output: %w", readErr)
 		}
+		return nil, errors.New("no output")
 	}
+
+	return &ffmpegProc{cmd: cmd, stdout: stdout, first: first[:n]}, nil
 }
 
-func buildFFmpegArgsNVENC(clips []Clip, audioIdx []int, fade time.Duration) []string {
+var pipeOutput = []string{
+	// Output MPEG-TS to stdout (pipe:1)
+	"-f", "mpegts",
+	"-muxdelay", "0",
+	"-muxpreload", "0",
+	"pipe:1",
+}
+
+func buildFFmpegArgs(clips []Clip, audioIdx []int, fade time.Duration, profile EncoderProfile, output ...string) []string {
 	args := make([]string, 0, 128)
+	args = append(args, profile.globalArgs()...)
 
 	// Inputs
 	for _, c := range clips {
@@ -224,6 +522,11 @@ func buildFFmpegArgsNVENC(clips []Clip, audioIdx []int, fade time.Duration) []st
 		outA = nextA
 	}
 
+	if upload := profile.uploadFilter(); upload != "" {
+		fmt.Fprintf(&b, "[%s]%s[vout];", outV, upload)
+		outV = "vout"
+	}
+
 	args = append(args,
 		"-hide_banner",
 		"-loglevel", "warning",
@@ -231,28 +534,18 @@ func buildFFmpegArgsNVENC(clips []Clip, audioIdx []int, fade time.Duration) []st
 		"-filter_complex", b.String(),
 		"-map", fmt.Sprintf("[%s]", outV),
 		"-map", fmt.Sprintf("[%s]", outA),
+	)
 
-		// Encode with GPU (NVENC)
-		"-c:v", "h264_nvenc",
-		"-preset", "p5",
-		"-tune", "ll",
-		"-rc", "cbr",
-		"-b:v", "25M",
-		"-maxrate", "25M",
-		"-bufsize", "12M",
-		"-g", "120",
+	args = append(args, profile.videoArgs()...)
 
+	args = append(args,
 		// Audio AAC 128k
 		"-c:a", "aac",
 		"-b:a", "128k",
-
-		// Output MPEG-TS to stdout (pipe:1)
-		"-f", "mpegts",
-		"-muxdelay", "0",
-		"-muxpreload", "0",
-		"pipe:1",
 	)
 
+	args = append(args, output...)
+
 	return args
 }
 
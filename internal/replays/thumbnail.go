@@ -0,0 +1,55 @@
+package replays
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// HandleHighlightThumbnail serves a small JPEG frame from a highlight's
+// recording, taken at its first event.
+func (s *Streamer) HandleHighlightThumbnail(w http.ResponseWriter, r *http.Request) {
+	start, err := strconv.ParseUint(r.PathValue("start"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid highlight start time", http.StatusBadRequest)
+		return
+	}
+
+	hl := findHighlight(s.Store.Get(), start)
+	if hl == nil {
+		http.Error(w, ErrHighlightNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	offsetMs := hl.Duration / 2
+	if len(hl.EventsTimestamps) > 0 {
+		offsetMs = hl.EventsTimestamps[0]
+	}
+
+	cmd := exec.CommandContext(r.Context(), s.FFmpegBin,
+		"-hide_banner", "-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", float64(offsetMs)/1000.0),
+		"-i", hl.MediaPath,
+		"-frames:v", "1",
+		"-vf", "scale=320:-2",
+		"-f", "image2pipe", "-c:v", "mjpeg",
+		"pipe:1",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil || len(out) == 0 {
+		log.Printf("[ffmpeg] thumbnail %s failed: %v %s", hl.MediaPath, err, strings.TrimSpace(stderr.String()))
+		http.Error(w, "thumbnail failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	_, _ = w.Write(out)
+}
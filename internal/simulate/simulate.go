@@ -0,0 +1,449 @@
+// Package simulate generates a synthetic match as a timeline of game event
+// payloads in the format the game client posts to /events/game_event.
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/capture"
+	"github.com/akayumeru/valreplayserver/internal/domain"
+	"github.com/akayumeru/valreplayserver/internal/valorant"
+)
+
+type Options struct {
+	Seed uint64
+
+	// RoundsToWin ends the match when a team reaches it; tied at
+	// RoundsToWin-1 the match goes to overtime (win by two).
+	RoundsToWin int
+	// MaxRounds stops the match early (0 = no limit).
+	MaxRounds int
+
+	// AgentSelect is how long agent select lasts before round 1.
+	AgentSelect time.Duration
+
+	MatchID string
+	Map     string
+	Start   time.Time
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Seed:        1,
+		RoundsToWin: valorant.RoundsPerHalf + 1,
+		AgentSelect: 30 * time.Second,
+	}
+}
+
+var maps = []string{"Ascent", "Bonsai", "Triad", "Duality", "Port", "Foxtrot", "Pitt", "Jam", "Juliett"}
+
+var weapons = []string{"TX_Hud_Vandal", "TX_Hud_Phantom", "TX_Hud_Operator", "TX_Hud_Sheriff", "TX_Hud_Spectre", "TX_Hud_Ghost", "TX_Hud_Guardian"}
+
+var names = []string{
+	"Cobalt #EUW", "Nyx #0001", "Tarsier #GG", "Marrow #777", "Quill #1337",
+	"Brisk #NA1", "Ember #420", "Halcyon #99", "Vesper #OCE", "Rook #TR1",
+}
+
+type player struct {
+	id       string
+	name     string
+	agent    string
+	teammate bool
+	local    bool
+
+	kills, deaths, assists int
+	alive                  bool
+}
+
+type generator struct {
+	opts Options
+	rng  *rand.Rand
+
+	at      time.Time
+	entries []capture.Entry
+
+	players []*player // allies first; players[0] is the local player
+}
+
+// Match returns the payloads of one full match, timestamped from opts.Start
+// with real game phase durations; play them back with capture.Play.
+func Match(opts Options) []capture.Entry {
+	if opts.RoundsToWin <= 0 {
+		opts.RoundsToWin = DefaultOptions().RoundsToWin
+	}
+	if opts.Start.IsZero() {
+		opts.Start = time.Now().UTC()
+	}
+
+	g := &generator{
+		opts: opts,
+		rng:  rand.New(rand.NewPCG(opts.Seed, opts.Seed^0x9e3779b97f4a7c15)),
+		at:   opts.Start,
+	}
+	if g.opts.MatchID == "" {
+		g.opts.MatchID = fmt.Sprintf("sim-%d", opts.Seed)
+	}
+	if g.opts.Map == "" {
+		g.opts.Map = maps[g.rng.IntN(len(maps))]
+	}
+
+	g.agentSelect()
+	g.rounds()
+
+	return g.entries
+}
+
+func (g *generator) emit(payload any) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		panic(err)
+	}
+	g.entries = append(g.entries, capture.Entry{At: g.at, Body: b})
+}
+
+func (g *generator) wait(d time.Duration) {
+	g.at = g.at.Add(d)
+}
+
+func (g *generator) matchInfo(kv map[string]any) {
+	g.emit(map[string]any{"match_info": kv})
+}
+
+func (g *generator) events(evs ...valorant.RawEvent) {
+	g.emit(map[string]any{"events": evs})
+}
+
+func event(name string, data string) valorant.RawEvent {
+	raw, _ := json.Marshal(data)
+	return valorant.RawEvent{Name: name, Data: raw}
+}
+
+// stringified encodes v as JSON inside a JSON string, like the client does
+// for roster, scoreboard and kill feed values.
+func stringified(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func (g *generator) agentSelect() {
+	agents := make([]string, 0, len(valorant.AgentByInternal))
+	for internal := range valorant.AgentByInternal {
+		agents = append(agents, internal)
+	}
+	sort.Strings(agents)
+	g.rng.Shuffle(len(agents), func(i, j int) { agents[i], agents[j] = agents[j], agents[i] })
+
+	for i := 0; i < 10; i++ {
+		g.players = append(g.players, &player{
+			id:       fmt.Sprintf("sim-player-%d", i),
+			name:     names[i],
+			agent:    agents[i%len(agents)],
+			teammate: i < 5,
+			local:    i == 0,
+		})
+	}
+
+	local := g.players[0]
+	g.emit(map[string]any{"me": map[string]any{"player_name": local.name, "player_id": local.id}})
+	g.emit(map[string]any{"game_info": map[string]any{"scene": "CharacterSelectPersistentLevel", "state": "InGame"}})
+	g.matchInfo(map[string]any{"match_id": g.opts.MatchID, "map": g.opts.Map})
+
+	// everyone shows up unlocked, then locks in at a random moment
+	roster := map[string]any{}
+	for i, p := range g.players {
+		roster[fmt.Sprintf("roster_%d", i)] = stringified(g.rosterPlayer(p, false))
+	}
+	g.matchInfo(roster)
+
+	order := g.rng.Perm(len(g.players))
+	step := g.opts.AgentSelect / time.Duration(len(order)+1)
+	for _, i := range order {
+		g.wait(step)
+		g.matchInfo(map[string]any{fmt.Sprintf("roster_%d", i): stringified(g.rosterPlayer(g.players[i], true))})
+	}
+	g.wait(step)
+
+	g.emit(map[string]any{"game_info": map[string]any{"scene": g.opts.Map}})
+	g.events(event("match_start", ""))
+}
+
+func (g *generator) rosterPlayer(p *player, locked bool) domain.RosterPlayer {
+	return domain.RosterPlayer{
+		Name:      p.name,
+		PlayerID:  p.id,
+		Character: p.agent,
+		Rank:      10 + g.rng.IntN(15),
+		Locked:    locked,
+		Local:     p.local,
+		Teammate:  p.teammate,
+	}
+}
+
+func (g *generator) rounds() {
+	startSide := valorant.SideAttack
+	if g.rng.IntN(2) == 0 {
+		startSide = valorant.SideDefense
+	}
+
+	won, lost := 0, 0
+	for round := 1; ; round++ {
+		allyWins := g.round(round, startSide, won, lost)
+		if allyWins {
+			won++
+		} else {
+			lost++
+		}
+		g.matchInfo(map[string]any{"score": stringified(map[string]int{"won": won, "lost": lost})})
+
+		if done, allyWon := g.matchOver(won, lost); done || (g.opts.MaxRounds > 0 && round >= g.opts.MaxRounds) {
+			g.wait(valorant.PhaseDuration["end"])
+			outcome := "defeat"
+			if done && allyWon || !done && won > lost {
+				outcome = "victory"
+			}
+			g.matchInfo(map[string]any{"round_phase": "game_end", "match_outcome": outcome})
+			g.wait(valorant.PhaseDuration["game_end"])
+			g.events(event("match_end", ""))
+			return
+		}
+
+		g.wait(valorant.PhaseDuration["end"])
+	}
+}
+
+func (g *generator) matchOver(won, lost int) (bool, bool) {
+	need := g.opts.RoundsToWin
+	if won >= need-1 && lost >= need-1 {
+		// overtime: win by two
+		switch {
+		case won-lost >= 2:
+			return true, true
+		case lost-won >= 2:
+			return true, false
+		}
+		return false, false
+	}
+	return won >= need || lost >= need, won >= need
+}
+
+type kill struct {
+	at       time.Duration
+	attacker *player
+	victim   *player
+	assist   *player
+	headshot bool
+	weapon   string
+}
+
+// round plays one round and reports whether the local player's team won it.
+func (g *generator) round(number int, startSide string, won, lost int) bool {
+	allySide := valorant.SideForRound(startSide, number)
+
+	for _, p := range g.players {
+		p.alive = true
+	}
+
+	// round_phase goes in its own payload, as the game client sends it
+	g.matchInfo(map[string]any{"round_number": fmt.Sprint(number), "team": allySide})
+	g.matchInfo(map[string]any{"round_phase": "shopping"})
+	g.scoreboard(won, lost)
+	g.wait(valorant.PhaseDuration["shopping"])
+
+	g.matchInfo(map[string]any{"round_phase": "combat"})
+	combatStart := g.at
+
+	allyWins := g.rng.Float64() < 0.5
+	winners, losers := g.teams(allyWins)
+
+	loserDeaths := 5
+	if g.rng.Float64() < 0.3 {
+		loserDeaths = 2 + g.rng.IntN(3)
+	}
+	winnerDeaths := g.rng.IntN(5)
+
+	victims := make([]*player, 0, loserDeaths+winnerDeaths)
+	victims = append(victims, pick(g.rng, losers, loserDeaths)...)
+	victims = append(victims, pick(g.rng, winners, winnerDeaths)...)
+	g.rng.Shuffle(len(victims), func(i, j int) { victims[i], victims[j] = victims[j], victims[i] })
+	if loserDeaths == 5 {
+		// the round ends on the last loser's death
+		for i := len(victims) - 1; i >= 0; i-- {
+			if victims[i].teammate != allyWins {
+				victims[i], victims[len(victims)-1] = victims[len(victims)-1], victims[i]
+				break
+			}
+		}
+	}
+
+	combat := valorant.PhaseDuration["combat"] - 10*time.Second
+	times := make([]time.Duration, len(victims))
+	for i := range times {
+		times[i] = 5*time.Second + time.Duration(g.rng.Int64N(int64(combat-5*time.Second)))
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	attackersWin := (allySide == valorant.SideAttack) == allyWins
+	var plantAt time.Duration
+	if attackersWin || g.rng.Float64() < 0.5 {
+		plantAt = combat / 2
+	}
+
+	var planted bool
+	var damage, headshots int
+	for i, victim := range victims {
+		if plantAt > 0 && !planted && times[i] > plantAt {
+			g.at = combatStart.Add(plantAt)
+			g.events(event("spike_planted", ""))
+			planted = true
+		}
+
+		killers := aliveOf(g.players, !victim.teammate)
+		if len(killers) == 0 {
+			break
+		}
+		k := kill{
+			at:       times[i],
+			attacker: killers[g.rng.IntN(len(killers))],
+			victim:   victim,
+			headshot: g.rng.Float64() < 0.3,
+			weapon:   weapons[g.rng.IntN(len(weapons))],
+		}
+		if len(killers) > 1 && g.rng.Float64() < 0.5 {
+			for k.assist = killers[g.rng.IntN(len(killers))]; k.assist == k.attacker; {
+				k.assist = killers[g.rng.IntN(len(killers))]
+			}
+		}
+
+		g.at = combatStart.Add(k.at)
+		victim.alive = false
+		victim.deaths++
+		k.attacker.kills++
+		if k.assist != nil {
+			k.assist.assists++
+		}
+
+		evs := []valorant.RawEvent{event("kill_feed", g.killFeedEntry(k))}
+		switch g.players[0] {
+		case k.attacker:
+			evs = append(evs, event("kill", fmt.Sprint(k.attacker.kills)))
+			if k.headshot {
+				evs = append(evs, event("headshot", fmt.Sprint(headshots+1)))
+				headshots++
+			}
+			dmg := 140 + g.rng.IntN(60)
+			damage += dmg
+			evs = append(evs, event("damage", fmt.Sprint(dmg)))
+		case k.victim:
+			evs = append(evs, event("death", fmt.Sprint(victim.deaths)))
+		case k.assist:
+			evs = append(evs, event("assist", fmt.Sprint(k.assist.assists)))
+		}
+		g.events(evs...)
+	}
+
+	losersAlive := len(aliveOf(losers, !allyWins)) > 0
+	if plantAt > 0 && !planted && (losersAlive || !attackersWin) {
+		g.at = later(g.at, combatStart.Add(plantAt))
+		g.events(event("spike_planted", ""))
+		planted = true
+	}
+
+	switch {
+	case planted && !attackersWin:
+		g.wait(5 * time.Second)
+		g.events(event("spike_defused", ""))
+	case planted && losersAlive:
+		g.wait(5 * time.Second)
+		g.events(event("spike_detonated", ""))
+	case losersAlive:
+		// defenders held until the timer ran out
+		g.at = later(g.at, combatStart.Add(combat))
+	}
+
+	g.wait(2 * time.Second)
+	g.matchInfo(map[string]any{
+		"round_phase":  "end",
+		"round_report": stringified(domain.RoundReport{Damage: damage, Hit: damage / 30, Headshot: headshots}),
+	})
+
+	return allyWins
+}
+
+func (g *generator) teams(allyWins bool) (winners, losers []*player) {
+	for _, p := range g.players {
+		if p.teammate == allyWins {
+			winners = append(winners, p)
+		} else {
+			losers = append(losers, p)
+		}
+	}
+	return winners, losers
+}
+
+func (g *generator) killFeedEntry(k kill) string {
+	e := domain.KillFeedEntry{
+		Attacker:           k.attacker.name,
+		Victim:             k.victim.name,
+		Headshot:           k.headshot,
+		Weapon:             k.weapon,
+		IsAttackerTeammate: k.attacker.teammate,
+		IsVictimTeammate:   k.victim.teammate,
+	}
+	if k.assist != nil {
+		e.Assist1 = k.assist.name
+	}
+	return stringified(e)
+}
+
+func (g *generator) scoreboard(won, lost int) {
+	kv := make(map[string]any, len(g.players))
+	for i, p := range g.players {
+		kv[fmt.Sprintf("scoreboard_%d", i)] = stringified(domain.ScoreboardEntry{
+			PlayerID:  p.id,
+			Name:      p.name,
+			Character: p.agent,
+			Teammate:  p.teammate,
+			Alive:     true,
+			Kills:     p.kills,
+			Deaths:    p.deaths,
+			Assists:   p.assists,
+			Money:     800 + 200*g.rng.IntN(30) + 500*(won+lost),
+			UltPoints: g.rng.IntN(8),
+			UltMax:    7 + g.rng.IntN(2),
+			Shield:    []int{0, 25, 50}[g.rng.IntN(3)],
+			Weapon:    weapons[g.rng.IntN(len(weapons))],
+			IsLocal:   p.local,
+		})
+	}
+	g.matchInfo(kv)
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func aliveOf(players []*player, teammate bool) []*player {
+	var out []*player
+	for _, p := range players {
+		if p.alive && p.teammate == teammate {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func pick(rng *rand.Rand, players []*player, n int) []*player {
+	idx := rng.Perm(len(players))
+	out := make([]*player, 0, n)
+	for _, i := range idx[:min(n, len(players))] {
+		out = append(out, players[i])
+	}
+	return out
+}
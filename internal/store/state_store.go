@@ -8,15 +8,36 @@ import (
 	"github.com/akayumeru/valreplayserver/internal/domain"
 )
 
+// DefaultHistoryLimit keeps the history small: every change holds a full
+// copy of the state, which late in a match with its rounds and highlights
+// is tens of KB, so 200 changes cost a few MB.
+const DefaultHistoryLimit = 200
+
+// Change is one applied update: the state it produced, when and why.
+type Change struct {
+	Version uint64       `json:"version"`
+	At      time.Time    `json:"at"`
+	Cause   string       `json:"cause"`
+	State   domain.State `json:"-"`
+}
+
 type StateStore struct {
 	mu      sync.Mutex
 	current atomic.Value
 	version atomic.Uint64
+
+	// HistoryLimit caps how many changes are kept; older ones are dropped
+	// first. 0 disables history.
+	HistoryLimit int
+
+	history []Change
 }
 
 func NewStateStore(initial domain.State) *StateStore {
-	s := &StateStore{}
+	s := &StateStore{HistoryLimit: DefaultHistoryLimit}
+	initial.Version = 0
 	s.current.Store(initial)
+	s.record("initial", initial, time.Now().UTC())
 
 	return s
 }
@@ -29,25 +50,114 @@ func (s *StateStore) Version() uint64 {
 	return s.version.Load()
 }
 
-func (s *StateStore) Update(fn func(cur domain.State) domain.State) domain.State {
+// Update applies fn to the current state. cause is a short label kept in the
+// history, e.g. "game_event" or "replay_created".
+func (s *StateStore) Update(cause string, fn func(cur domain.State) domain.State) domain.State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.store(cause, fn(s.Get()))
+}
+
+func (s *StateStore) Replace(cause string, next domain.State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.store(cause, next)
+}
+
+// Restore makes the state recorded at version current again, as a new
+// change with cause "restore". What describes the present rather than the
+// game (OBS connection, status and playback) is kept, and so are the replays:
+// bringing them back would undo deletions and hand out replay IDs again.
+// false when version is no longer in the history.
+func (s *StateStore) Restore(version uint64) (domain.State, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	c, ok := s.at(version)
+	if !ok {
+		return domain.State{}, false
+	}
+
 	cur := s.Get()
-	next := fn(cur)
+	next := c.State.Clone()
+	next.ObsConnectionOptions = cur.ObsConnectionOptions
+	next.ObsStatus = cur.ObsStatus
+	next.Playback = cur.Playback
+	next.ReplayState = cur.ReplayState
+
+	return s.store("restore", next), true
+}
+
+// store makes next current; s.mu must be held.
+func (s *StateStore) store(cause string, next domain.State) domain.State {
 	next.UpdatedAt = time.Now().UTC()
+	next.Version = s.version.Add(1)
 
 	s.current.Store(next)
-	s.version.Add(1)
+	s.record(cause, next, next.UpdatedAt)
 
 	return next
 }
 
-func (s *StateStore) Replace(next domain.State) {
+// History returns the recorded changes after version since, oldest first,
+// without their states.
+func (s *StateStore) History(since uint64) []Change {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	next.UpdatedAt = time.Now().UTC()
-	s.current.Store(next)
-	s.version.Add(1)
+	out := make([]Change, 0, len(s.history))
+	for _, c := range s.history {
+		if c.Version > since {
+			c.State = domain.State{}
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// At returns the change that produced version, or false once it has been
+// dropped from the history.
+func (s *StateStore) At(version uint64) (Change, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.at(version)
+	if !ok {
+		return Change{}, false
+	}
+	c.State = c.State.Clone()
+	return c, true
+}
+
+// at finds version in the history; s.mu must be held.
+func (s *StateStore) at(version uint64) (Change, bool) {
+	if len(s.history) == 0 {
+		return Change{}, false
+	}
+	// versions are contiguous
+	i := int(version) - int(s.history[0].Version)
+	if i < 0 || i >= len(s.history) {
+		return Change{}, false
+	}
+	return s.history[i], true
+}
+
+func (s *StateStore) record(cause string, st domain.State, at time.Time) {
+	if s.HistoryLimit <= 0 {
+		s.history = nil
+		return
+	}
+
+	// callers keep mutating rounds and highlights in place, so store a copy
+	s.history = append(s.history, Change{
+		Version: s.version.Load(),
+		At:      at,
+		Cause:   cause,
+		State:   st.Clone(),
+	})
+	if over := len(s.history) - s.HistoryLimit; over > 0 {
+		s.history = append(s.history[:0:0], s.history[over:]...)
+	}
 }
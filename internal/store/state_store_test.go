@@ -0,0 +1,47 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+)
+
+func TestStateStoreRestore(t *testing.T) {
+	s := NewStateStore(domain.State{})
+
+	good := s.Update("match_info", func(cur domain.State) domain.State {
+		cur.MatchInfo.Map = "Ascent"
+		cur.ReplayState.CurrentReplayId = 1
+		return cur
+	})
+	s.Update("bad", func(cur domain.State) domain.State {
+		cur.MatchInfo.Map = "Bind"
+		cur.ObsStatus.State = "connected"
+		cur.ReplayState.CurrentReplayId = 2
+		return cur
+	})
+
+	st, ok := s.Restore(good.Version)
+	if !ok {
+		t.Fatalf("Restore(%d) not ok", good.Version)
+	}
+	if st.MatchInfo.Map != "Ascent" {
+		t.Errorf("restored map = %q, want Ascent", st.MatchInfo.Map)
+	}
+	if st.ObsStatus.State != "connected" {
+		t.Error("restore rolled back the OBS status")
+	}
+	if st.ReplayState.CurrentReplayId != 2 {
+		t.Errorf("CurrentReplayId = %d, want 2; restore must not hand out replay IDs again", st.ReplayState.CurrentReplayId)
+	}
+	if st.Version != good.Version+2 || s.Get().Version != st.Version {
+		t.Errorf("restored as version %d, current %d; want %d", st.Version, s.Get().Version, good.Version+2)
+	}
+	if h := s.History(0); h[len(h)-1].Cause != "restore" {
+		t.Errorf("last change = %q, want restore", h[len(h)-1].Cause)
+	}
+
+	if _, ok := s.Restore(100); ok {
+		t.Error("Restore of a version not in the history ok")
+	}
+}
@@ -1,44 +1,307 @@
 package stream
 
-import "sync"
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBufferSize       = 64
+	DefaultSubscriberBuffer = 16
+)
+
+// Policy decides what Publish does when a subscriber's buffer is full.
+type Policy string
+
+const (
+	// PolicyDropOldest discards the oldest queued message to make room.
+	PolicyDropOldest Policy = "drop-oldest"
+	// PolicyCoalesce discards everything queued and keeps only the new
+	// message. For topics where each message replaces the previous one.
+	PolicyCoalesce Policy = "coalesce-latest"
+	// PolicyDisconnect closes the subscriber's channel; the client is
+	// expected to reconnect and resume.
+	PolicyDisconnect Policy = "disconnect"
+)
+
+func (p Policy) Valid() bool {
+	switch p {
+	case PolicyDropOldest, PolicyCoalesce, PolicyDisconnect:
+		return true
+	}
+	return false
+}
+
+// Message is one published payload. Seq counts up from 1 per topic.
+type Message struct {
+	Seq     uint64
+	Payload []byte
+
+	// Event overrides the SSE event name streams use for the topic.
+	Event string
+	// Version is the state version the payload was rendered from, when the
+	// publisher knows it. A stream that just sent a full state of that
+	// version or newer skips the message.
+	Version uint64
+}
+
+type subscriber struct {
+	ch      chan Message
+	dropped uint64
+}
+
+type topicState struct {
+	seq  uint64
+	ring []Message // last BufferSize messages, oldest first
+	subs map[chan Message]*subscriber
+
+	dropped      uint64
+	disconnected uint64
+}
+
+// TopicStats is a snapshot of one topic's delivery counters.
+type TopicStats struct {
+	Topic        string `json:"topic"`
+	Policy       Policy `json:"policy"`
+	Seq          uint64 `json:"seq"`
+	Buffered     int    `json:"buffered"`
+	Dropped      uint64 `json:"dropped"`
+	Disconnected uint64 `json:"disconnected"`
+
+	// SubscriberDrops has one entry per connected subscriber.
+	SubscriberDrops []uint64 `json:"subscriberDrops"`
+}
 
 type Hub struct {
-	mu   sync.RWMutex
-	subs map[string]map[chan []byte]struct{}
+	// BufferSize is how many recent messages each topic keeps for
+	// subscribers that fell behind or reconnected.
+	BufferSize int
+
+	// SubscriberBuffer is the channel size of each subscriber.
+	SubscriberBuffer int
+	// Policies picks the slow-consumer policy per topic; topics not listed
+	// use DefaultPolicy.
+	Policies      map[string]Policy
+	DefaultPolicy Policy
+
+	mu     sync.RWMutex
+	topics map[string]*topicState
+
+	// epoch tells event IDs from a previous server run apart from ours
+	epoch string
 }
 
 func NewHub() *Hub {
-	return &Hub{subs: make(map[string]map[chan []byte]struct{})}
+	return &Hub{
+		BufferSize:       DefaultBufferSize,
+		SubscriberBuffer: DefaultSubscriberBuffer,
+		DefaultPolicy:    PolicyDropOldest,
+		topics:           make(map[string]*topicState),
+		epoch:            strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
 }
 
-func (h *Hub) Subscribe(topic string) (ch chan []byte, cancel func()) {
-	ch = make(chan []byte)
+// topic returns the state for name; h.mu must be held for writing.
+func (h *Hub) topic(name string) *topicState {
+	t := h.topics[name]
+	if t == nil {
+		t = &topicState{subs: make(map[chan Message]*subscriber)}
+		h.topics[name] = t
+	}
+	return t
+}
 
-	h.mu.Lock()
-	if h.subs[topic] == nil {
-		h.subs[topic] = make(map[chan []byte]struct{})
+// Policy returns the policy for topic. Besides exact names, Policies may
+// hold "prefix/*" entries matching every topic under prefix/.
+func (h *Hub) Policy(topic string) Policy {
+	if p, ok := h.Policies[topic]; ok {
+		return p
 	}
-	h.subs[topic][ch] = struct{}{}
+	if i := strings.LastIndexByte(topic, '/'); i >= 0 {
+		if p, ok := h.Policies[topic[:i+1]+"*"]; ok {
+			return p
+		}
+	}
+	return h.DefaultPolicy
+}
+
+// Subscribe registers a subscriber on topic. The channel is closed by cancel,
+// or by the hub when the topic's policy is PolicyDisconnect and the
+// subscriber fell behind.
+func (h *Hub) Subscribe(topic string) (ch chan Message, cancel func()) {
+	ch = make(chan Message, max(h.SubscriberBuffer, 0))
+
+	h.mu.Lock()
+	h.topic(topic).subs[ch] = &subscriber{ch: ch}
 	h.mu.Unlock()
 
 	cancel = func() {
 		h.mu.Lock()
-		delete(h.subs[topic], ch)
-		h.mu.Unlock()
-		close(ch)
+		defer h.mu.Unlock()
+
+		t := h.topics[topic]
+		if _, ok := t.subs[ch]; ok {
+			delete(t.subs, ch)
+			close(ch)
+		}
 	}
 
 	return ch, cancel
 }
 
 func (h *Hub) Publish(topic string, payload []byte) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	h.PublishMessage(topic, Message{Payload: payload})
+}
+
+// PublishMessage is Publish with the optional Message fields set, e.g. an
+// Event to send a full "update" on a topic that otherwise carries
+// increments. msg.Seq is assigned by the hub.
+func (h *Hub) PublishMessage(topic string, msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	for ch := range h.subs[topic] {
+	t := h.topic(topic)
+	t.seq++
+	msg.Seq = t.seq
+
+	if h.BufferSize > 0 {
+		t.ring = append(t.ring, msg)
+		if over := len(t.ring) - h.BufferSize; over > 0 {
+			t.ring = append(t.ring[:0:0], t.ring[over:]...)
+		}
+	}
+
+	policy := h.Policy(topic)
+	for ch, sub := range t.subs {
 		select {
-		case ch <- payload:
+		case ch <- msg:
+			continue
 		default:
 		}
+
+		// Publish is the only sender and h.mu is held, so once room is
+		// made the send below cannot block
+		var dropped uint64
+		switch policy {
+		case PolicyDisconnect:
+			delete(t.subs, ch)
+			close(ch)
+			t.disconnected++
+			dropped = 1
+		case PolicyCoalesce:
+			dropped = drain(ch, -1)
+		default:
+			dropped = drain(ch, 1)
+		}
+
+		if policy != PolicyDisconnect {
+			select {
+			case ch <- msg:
+			default:
+				// unbuffered channel with no reader waiting
+				dropped++
+			}
+		}
+		sub.dropped += dropped
+		t.dropped += dropped
+	}
+}
+
+// drain takes up to n queued messages off ch (all of them if n < 0) and
+// returns how many it took.
+func drain(ch chan Message, n int) uint64 {
+	var taken uint64
+	for n < 0 || taken < uint64(n) {
+		select {
+		case <-ch:
+			taken++
+		default:
+			return taken
+		}
+	}
+	return taken
+}
+
+// Stats returns delivery counters for every topic seen so far, sorted by name.
+func (h *Hub) Stats() []TopicStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	out := make([]TopicStats, 0, len(h.topics))
+	for name, t := range h.topics {
+		st := TopicStats{
+			Topic:        name,
+			Policy:       h.Policy(name),
+			Seq:          t.seq,
+			Buffered:     len(t.ring),
+			Dropped:      t.dropped,
+			Disconnected: t.disconnected,
+
+			SubscriberDrops: make([]uint64, 0, len(t.subs)),
+		}
+		for _, sub := range t.subs {
+			st.SubscriberDrops = append(st.SubscriberDrops, sub.dropped)
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Topic < out[j].Topic })
+	return out
+}
+
+// Seq returns the sequence number of the last message published on topic.
+func (h *Hub) Seq(topic string) uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if t := h.topics[topic]; t != nil {
+		return t.seq
+	}
+	return 0
+}
+
+// Since returns the buffered messages published on topic after seq. ok is
+// false when some of them are no longer buffered or seq is from the future;
+// the caller has to start over from the current state then.
+func (h *Hub) Since(topic string, seq uint64) (msgs []Message, ok bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	t := h.topics[topic]
+	if t == nil {
+		return nil, seq == 0
+	}
+	if seq > t.seq {
+		return nil, false
+	}
+	if seq == t.seq {
+		return nil, true
+	}
+	if len(t.ring) == 0 || t.ring[0].Seq > seq+1 {
+		return nil, false
+	}
+
+	i := int(seq + 1 - t.ring[0].Seq)
+	return append([]Message(nil), t.ring[i:]...), true
+}
+
+// EventID formats seq as an SSE event ID tied to this hub instance.
+func (h *Hub) EventID(seq uint64) string {
+	return h.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// ParseEventID reverses EventID. IDs issued by another hub instance, e.g.
+// before a server restart, are rejected.
+func (h *Hub) ParseEventID(id string) (seq uint64, ok bool) {
+	epoch, s, found := strings.Cut(id, "-")
+	if !found || epoch != h.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false
 	}
+	return seq, true
 }
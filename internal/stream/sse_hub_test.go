@@ -0,0 +1,183 @@
+package stream
+
+import (
+	"reflect"
+	"testing"
+)
+
+func seqs(msgs []Message) []uint64 {
+	out := make([]uint64, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, m.Seq)
+	}
+	return out
+}
+
+// queued takes everything buffered on ch without blocking.
+func queued(ch chan Message) []uint64 {
+	var out []uint64
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				return out
+			}
+			out = append(out, m.Seq)
+		default:
+			return out
+		}
+	}
+}
+
+func TestHubSince(t *testing.T) {
+	h := NewHub()
+	h.BufferSize = 2
+
+	if msgs, ok := h.Since("t", 0); !ok || len(msgs) != 0 {
+		t.Errorf("Since(0) on an unknown topic = %v, %v; want nothing, true", msgs, ok)
+	}
+	if _, ok := h.Since("t", 1); ok {
+		t.Error("Since(1) on an unknown topic = true")
+	}
+
+	for range 3 {
+		h.Publish("t", []byte("x"))
+	}
+	h.PublishMessage("t", Message{Payload: []byte("y"), Event: "update", Version: 7})
+
+	tests := []struct {
+		since uint64
+		want  []uint64
+		ok    bool
+	}{
+		{since: 0, ok: false},                      // 1 and 2 were evicted
+		{since: 1, ok: false},                      // 2 was evicted
+		{since: 2, want: []uint64{3, 4}, ok: true}, // the whole buffer
+		{since: 3, want: []uint64{4}, ok: true},
+		{since: 4, want: []uint64{}, ok: true}, // up to date
+		{since: 5, ok: false},                  // ahead of the topic
+		{since: 100, ok: false},
+	}
+	for _, tt := range tests {
+		msgs, ok := h.Since("t", tt.since)
+		if ok != tt.ok {
+			t.Errorf("Since(%d) ok = %v, want %v", tt.since, ok, tt.ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(seqs(msgs), tt.want) {
+			t.Errorf("Since(%d) = %v, want %v", tt.since, seqs(msgs), tt.want)
+		}
+	}
+
+	msgs, _ := h.Since("t", 3)
+	if m := msgs[0]; m.Event != "update" || m.Version != 7 || string(m.Payload) != "y" {
+		t.Errorf("PublishMessage stored %+v", m)
+	}
+}
+
+func TestHubPolicyWhenFull(t *testing.T) {
+	tests := []struct {
+		policy       Policy
+		queued       []uint64
+		closed       bool
+		dropped      uint64
+		disconnected uint64
+	}{
+		{policy: PolicyDropOldest, queued: []uint64{3, 4}, dropped: 2},
+		// 3 finds the buffer full and replaces 1 and 2; 4 fits
+		{policy: PolicyCoalesce, queued: []uint64{3, 4}, dropped: 2},
+		// what was queued before the disconnect is still delivered
+		{policy: PolicyDisconnect, queued: []uint64{1, 2}, closed: true, dropped: 1, disconnected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			h := NewHub()
+			h.SubscriberBuffer = 2
+			h.Policies = map[string]Policy{"t": tt.policy}
+
+			ch, cancel := h.Subscribe("t")
+			defer cancel()
+
+			for range 4 {
+				h.Publish("t", nil)
+			}
+
+			st := h.Stats()[0]
+			if st.Dropped != tt.dropped || st.Disconnected != tt.disconnected {
+				t.Errorf("dropped %d, disconnected %d; want %d, %d", st.Dropped, st.Disconnected, tt.dropped, tt.disconnected)
+			}
+			if tt.closed != (len(st.SubscriberDrops) == 0) {
+				t.Errorf("subscribers after publishing: %v", st.SubscriberDrops)
+			}
+
+			if got := queued(ch); !reflect.DeepEqual(got, tt.queued) {
+				t.Errorf("queued %v, want %v", got, tt.queued)
+			}
+			if tt.closed {
+				if _, open := <-ch; open {
+					t.Error("channel still open")
+				}
+			}
+		})
+	}
+}
+
+func TestHubCoalesceKeepsNewest(t *testing.T) {
+	h := NewHub()
+	h.SubscriberBuffer = 3
+	h.Policies = map[string]Policy{"prefix/*": PolicyCoalesce}
+
+	ch, cancel := h.Subscribe("prefix/t")
+	defer cancel()
+
+	for range 5 {
+		h.Publish("prefix/t", nil)
+	}
+	// 4 found 1, 2 and 3 queued and replaced them all
+	if got := queued(ch); !reflect.DeepEqual(got, []uint64{4, 5}) {
+		t.Errorf("queued %v, want [4 5]", got)
+	}
+}
+
+func TestHubCancelAfterDisconnect(t *testing.T) {
+	h := NewHub()
+	h.SubscriberBuffer = 1
+	h.DefaultPolicy = PolicyDisconnect
+
+	ch, cancel := h.Subscribe("t")
+	h.Publish("t", nil)
+	h.Publish("t", nil) // disconnects
+
+	<-ch
+	if _, open := <-ch; open {
+		t.Fatal("channel still open after the hub disconnected the subscriber")
+	}
+
+	// must neither panic on the closed channel nor disturb other subscribers
+	other, cancelOther := h.Subscribe("t")
+	defer cancelOther()
+	cancel()
+	cancel()
+
+	h.Publish("t", nil)
+	if m := <-other; m.Seq != 3 {
+		t.Errorf("other subscriber got seq %d, want 3", m.Seq)
+	}
+	if n := len(h.Stats()[0].SubscriberDrops); n != 1 {
+		t.Errorf("%d subscribers, want 1", n)
+	}
+}
+
+func TestHubEventID(t *testing.T) {
+	h := NewHub()
+
+	if seq, ok := h.ParseEventID(h.EventID(42)); !ok || seq != 42 {
+		t.Errorf("round trip = %d, %v", seq, ok)
+	}
+	for _, id := range []string{"", "42", NewHub().EventID(42) + "x", h.EventID(1) + "-1"} {
+		if _, ok := h.ParseEventID(id); ok {
+			t.Errorf("ParseEventID(%q) accepted", id)
+		}
+	}
+}
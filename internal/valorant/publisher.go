@@ -2,6 +2,8 @@ package valorant
 
 import (
 	"encoding/json"
+	"errors"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +18,20 @@ type Topics struct {
 	TriggerReplay     bool
 	Highlight         bool
 	StartReplayBuffer bool
+	RoundOutcome      bool
+	Spike             bool
+	PlayerStats       bool
+	Scoreboard        bool
+
+	// NewKillFeedEntries is how many entries at the tail of MatchInfo.KillFeed were added.
+	NewKillFeedEntries int
+	// KillFeedReset is set when the kill feed was cleared; screens need the
+	// whole feed then, not just the new entries.
+	KillFeedReset bool
+
+	// MatchEnded is set by match_end, which clears the rounds and kill feed;
+	// the state passed in still has them.
+	MatchEnded bool
 }
 
 func (t Topics) List() []string {
@@ -35,9 +51,27 @@ func (t Topics) List() []string {
 	if t.StartReplayBuffer {
 		out = append(out, "start_replay_buffer")
 	}
+	if t.RoundOutcome {
+		out = append(out, "round_outcome")
+	}
+	if t.Spike {
+		out = append(out, "spike")
+	}
+	if t.PlayerStats {
+		out = append(out, "player_stats")
+	}
+	if t.Scoreboard {
+		out = append(out, "scoreboard")
+	}
+	if t.NewKillFeedEntries > 0 || t.KillFeedReset {
+		out = append(out, "kill_feed")
+	}
 	return out
 }
 
+// now is swapped out by tests to make state timestamps deterministic.
+var now = func() time.Time { return time.Now().UTC() }
+
 func ApplyPayload(cur domain.State, payload []byte) (domain.State, Topics, error) {
 	env, root, err := ParseEnvelope(payload)
 	if err != nil {
@@ -58,7 +92,7 @@ func ApplyPayload(cur domain.State, payload []byte) (domain.State, Topics, error
 		for _, e := range env.Events {
 			cur, touched = applyEvent(cur, e, touched)
 		}
-		cur.UpdatedAt = time.Now().UTC()
+		cur.UpdatedAt = now()
 
 		utils.DebugLog("Got events", env.Events)
 
@@ -93,7 +127,7 @@ func ApplyPayload(cur domain.State, payload []byte) (domain.State, Topics, error
 		utils.DebugLog("Got player info update", env.PlayerInfo)
 	}
 
-	cur.UpdatedAt = time.Now().UTC()
+	cur.UpdatedAt = now()
 	return cur, touched, nil
 }
 
@@ -136,7 +170,10 @@ func applyPlayerInfo(cur domain.State, pi map[string]json.RawMessage) domain.Sta
 }
 
 func applyMatchInfo(cur domain.State, mi map[string]json.RawMessage, touched Topics) (domain.State, Topics) {
-	for k, v := range mi {
+	var teamSide string
+
+	for _, k := range matchInfoKeys(mi) {
+		v := mi[k]
 		switch {
 		case k == "pseudo_match_id":
 			var s string
@@ -149,10 +186,20 @@ func applyMatchInfo(cur domain.State, mi map[string]json.RawMessage, touched Top
 			var s string
 			if json.Unmarshal(v, &s) == nil {
 				if cur.MatchInfo.MatchID != s {
+					touched.KillFeedReset = touched.KillFeedReset || len(cur.MatchInfo.KillFeed) > 0
 					cur.MatchInfo.Rounds = make(map[int]*domain.Round)
 					cur.MatchInfo.CurrentRound = nil
 					cur.MatchInfo.KillFeed = nil
 					cur.MatchInfo.Roster = make(map[string]domain.RosterPlayer)
+					cur.MatchInfo.Scoreboard = make(map[string]domain.ScoreboardEntry)
+					cur.MatchInfo.Ally = domain.TeamState{}
+					cur.MatchInfo.Enemy = domain.TeamState{}
+					cur.MatchInfo.AllyStartingSide = ""
+					cur.MatchInfo.Half = 0
+					cur.MatchInfo.Overtime = false
+					cur.MatchInfo.MatchOutcome = ""
+					touched.PlayerPicks = true
+					touched.Scoreboard = true
 				}
 				cur.MatchInfo.MatchID = s
 				touched.MatchInfo = true
@@ -172,10 +219,10 @@ func applyMatchInfo(cur domain.State, mi map[string]json.RawMessage, touched Top
 					if cur.MatchInfo.CurrentRound == nil || cur.MatchInfo.CurrentRound.Number != n {
 						newRound := &domain.Round{
 							Number:          n,
-							StartedAt:       time.Now().UTC(),
-							EndedAt:         time.Now().UTC().Add(PhaseDuration["shopping"] + PhaseDuration["combat"] + PhaseDuration["end"] + 1*time.Second),
+							StartedAt:       now(),
+							EndedAt:         now().Add(PhaseDuration["shopping"] + PhaseDuration["combat"] + PhaseDuration["end"] + 1*time.Second),
 							LastPhase:       "shopping",
-							PhaseStartedAt:  time.Now().UTC(),
+							PhaseStartedAt:  now(),
 							HighlightsCount: 0,
 						}
 						if cur.MatchInfo.Rounds == nil {
@@ -186,6 +233,7 @@ func applyMatchInfo(cur domain.State, mi map[string]json.RawMessage, touched Top
 							cur.MatchInfo.CurrentRound.EndedAt = newRound.StartedAt.Add(-1 * time.Millisecond)
 						}
 						cur.MatchInfo.CurrentRound = newRound
+						cur = applySides(cur)
 
 						touched.TriggerReplay = true
 					}
@@ -201,12 +249,85 @@ func applyMatchInfo(cur domain.State, mi map[string]json.RawMessage, touched Top
 					if s == "combat" {
 						touched.StartReplayBuffer = true
 					}
-					cur.MatchInfo.CurrentRound.PhaseStartedAt = time.Now().UTC()
+					cur.MatchInfo.CurrentRound.PhaseStartedAt = now()
 					touched.MatchInfo = true
 				}
 			}
 
+		case k == "score":
+			var sc scorePayload
+			if unmarshalMaybeString(v, &sc) == nil {
+				if cr := cur.MatchInfo.CurrentRound; cr != nil && cr.Outcome == "" {
+					switch {
+					case sc.Won > cur.MatchInfo.Ally.RoundsWon:
+						cr.Outcome = "win"
+						touched.RoundOutcome = true
+					case sc.Lost > cur.MatchInfo.Enemy.RoundsWon:
+						cr.Outcome = "loss"
+						touched.RoundOutcome = true
+					}
+				}
+				cur.MatchInfo.Ally.RoundsWon = sc.Won
+				cur.MatchInfo.Enemy.RoundsWon = sc.Lost
+				touched.MatchInfo = true
+			}
+
+		case k == "round_report":
+			var rr domain.RoundReport
+			if unmarshalMaybeString(v, &rr) == nil && cur.MatchInfo.CurrentRound != nil {
+				cur.MatchInfo.CurrentRound.Report = &rr
+				touched.RoundOutcome = true
+				touched.PlayerStats = true
+			}
+
+		case k == "team":
+			var s string
+			if json.Unmarshal(v, &s) == nil && OppositeSide(s) != "" {
+				// applied after the loop, once round_number from the same payload is known
+				teamSide = s
+			}
+
+		case k == "match_outcome":
+			var s string
+			if json.Unmarshal(v, &s) == nil && s != "" {
+				cur.MatchInfo.MatchOutcome = s
+				touched.MatchInfo = true
+				touched.RoundOutcome = true
+			}
+
 		default:
+			if strings.HasPrefix(k, "scoreboard_") {
+				var e domain.ScoreboardEntry
+				if unmarshalMaybeString(v, &e) != nil {
+					continue
+				}
+
+				e.Name = NormalizeName(e.Name)
+				e.Character = NormalizeAgent(e.Character)
+
+				id := e.PlayerID
+				if id == "" {
+					// store under the roster ID right away so the entry
+					// replaces the roster placeholder instead of racing it
+					if pid, ok := rosterIDByName(cur.MatchInfo.Roster, e.Name); ok {
+						id = pid
+						e.PlayerID = pid
+					} else {
+						id = e.Name
+					}
+				}
+				if id == "" {
+					continue
+				}
+
+				if cur.MatchInfo.Scoreboard == nil {
+					cur.MatchInfo.Scoreboard = make(map[string]domain.ScoreboardEntry)
+				}
+				cur.MatchInfo.Scoreboard[id] = e
+				touched.Scoreboard = true
+				continue
+			}
+
 			if strings.HasPrefix(k, "roster_") {
 				var s string
 				if json.Unmarshal(v, &s) != nil || s == "" {
@@ -224,29 +345,129 @@ func applyMatchInfo(cur domain.State, mi map[string]json.RawMessage, touched Top
 					cur.MatchInfo.Roster[p.PlayerID] = p
 					touched.PlayerPicks = true
 					touched.MatchInfo = true
+					touched.Scoreboard = true
 				}
 			}
 		}
 	}
+
+	if touched.Scoreboard {
+		cur = syncScoreboard(cur)
+	}
+
+	if teamSide != "" {
+		round := 1
+		if cur.MatchInfo.CurrentRound != nil {
+			round = cur.MatchInfo.CurrentRound.Number
+		}
+		// SideForRound is its own inverse for a given round
+		cur.MatchInfo.AllyStartingSide = SideForRound(teamSide, round)
+		cur = applySides(cur)
+		touched.MatchInfo = true
+	}
+
 	return cur, touched
 }
 
+// matchInfoKeys orders the keys of one match_info payload: match_id first
+// (it resets the match), then score, round_report and match_outcome, which
+// close the current round, then round_number, so that round_phase and the
+// rest of the payload apply to the round it starts. Map order would make the
+// result depend on luck.
+func matchInfoKeys(mi map[string]json.RawMessage) []string {
+	rank := func(k string) int {
+		switch k {
+		case "match_id":
+			return 0
+		case "score", "round_report", "match_outcome":
+			return 1
+		case "round_number":
+			return 2
+		}
+		return 3
+	}
+
+	keys := make([]string, 0, len(mi))
+	for k := range mi {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ri, rj := rank(keys[i]), rank(keys[j]); ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
 func applyEvent(cur domain.State, e RawEvent, touched Topics) (domain.State, Topics) {
 	switch e.Name {
 	case "match_start":
 		touched.MatchInfo = true
 
 	case "match_end":
+		touched.KillFeedReset = touched.KillFeedReset || len(cur.MatchInfo.KillFeed) > 0
 		cur.MatchInfo.Rounds = nil
 		cur.MatchInfo.KillFeed = nil
 		cur.MatchInfo.CurrentRound = nil
+		touched.NewKillFeedEntries = 0
 		touched.MatchInfo = true
 		touched.TriggerReplay = true
+		touched.MatchEnded = true
 
 	case "kill":
 		if cur.MatchInfo.CurrentRound != nil {
 			cur.MatchInfo.CurrentRound.HighlightsCount++
+			cur.MatchInfo.CurrentRound.Kills++
 			touched.Highlight = true
+			touched.PlayerStats = true
+		}
+
+	case "death":
+		if cur.MatchInfo.CurrentRound != nil {
+			cur.MatchInfo.CurrentRound.Deaths++
+			touched.PlayerStats = true
+		}
+
+	case "assist":
+		if cur.MatchInfo.CurrentRound != nil {
+			cur.MatchInfo.CurrentRound.Assists++
+			touched.PlayerStats = true
+		}
+
+	case "headshot":
+		if cur.MatchInfo.CurrentRound != nil {
+			cur.MatchInfo.CurrentRound.Headshots++
+			touched.PlayerStats = true
+		}
+
+	case "damage":
+		if cur.MatchInfo.CurrentRound != nil {
+			if n, ok := parseInt(e.Data); ok {
+				cur.MatchInfo.CurrentRound.Damage += n
+				touched.PlayerStats = true
+			}
+		}
+
+	case "spike_planted":
+		if cur.MatchInfo.CurrentRound != nil {
+			cur.MatchInfo.CurrentRound.SpikePlantedAt = now()
+			touched.Spike = true
+			touched.MatchInfo = true
+		}
+
+	case "spike_defused":
+		if cur.MatchInfo.CurrentRound != nil {
+			cur.MatchInfo.CurrentRound.SpikeDefusedAt = now()
+			touched.Spike = true
+			touched.MatchInfo = true
+		}
+
+	case "spike_detonated":
+		if cur.MatchInfo.CurrentRound != nil {
+			cur.MatchInfo.CurrentRound.SpikeDetonatedAt = now()
+			touched.Spike = true
+			touched.MatchInfo = true
 		}
 
 	case "kill_feed":
@@ -263,8 +484,111 @@ func applyEvent(cur domain.State, e RawEvent, touched Topics) (domain.State, Top
 				}
 
 				touched.MatchInfo = true
+				touched.NewKillFeedEntries = min(touched.NewKillFeedEntries+1, len(cur.MatchInfo.KillFeed))
 			}
 		}
 	}
 	return cur, touched
 }
+
+// syncScoreboard keys scoreboard entries by roster player ID and fills in
+// identity fields from the roster, creating entries for players the client
+// has not reported stats for yet.
+func syncScoreboard(cur domain.State) domain.State {
+	sb := make(map[string]domain.ScoreboardEntry, len(cur.MatchInfo.Roster))
+	var byName []domain.ScoreboardEntry
+	for id, e := range cur.MatchInfo.Scoreboard {
+		if e.PlayerID == "" {
+			if _, ok := rosterIDByName(cur.MatchInfo.Roster, e.Name); ok {
+				byName = append(byName, e)
+				continue
+			}
+		}
+		sb[id] = e
+	}
+	// entries stored by name before the roster knew the player are newer
+	// than whatever sits under the player's ID (usually a placeholder)
+	for _, e := range byName {
+		pid, _ := rosterIDByName(cur.MatchInfo.Roster, e.Name)
+		e.PlayerID = pid
+		sb[pid] = e
+	}
+
+	for id, p := range cur.MatchInfo.Roster {
+		e, ok := sb[id]
+		if !ok {
+			e = domain.ScoreboardEntry{PlayerID: id, Alive: true}
+		}
+		if e.Name == "" {
+			e.Name = p.Name
+		}
+		if e.Character == "" {
+			e.Character = p.Character
+		}
+		e.Teammate = p.Teammate
+		e.IsLocal = p.Local
+		sb[id] = e
+	}
+
+	cur.MatchInfo.Scoreboard = sb
+	return cur
+}
+
+func rosterIDByName(roster map[string]domain.RosterPlayer, name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	for id, p := range roster {
+		if p.Name == name {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+type scorePayload struct {
+	Won  int `json:"won"`
+	Lost int `json:"lost"`
+}
+
+// applySides updates the half, overtime flag and both teams' sides for the current round.
+func applySides(cur domain.State) domain.State {
+	if cur.MatchInfo.CurrentRound == nil {
+		return cur
+	}
+	round := cur.MatchInfo.CurrentRound.Number
+
+	cur.MatchInfo.Half, cur.MatchInfo.Overtime = RoundHalf(round)
+	if cur.MatchInfo.AllyStartingSide != "" {
+		cur.MatchInfo.Ally.Side = SideForRound(cur.MatchInfo.AllyStartingSide, round)
+		cur.MatchInfo.Enemy.Side = OppositeSide(cur.MatchInfo.Ally.Side)
+	}
+	return cur
+}
+
+// unmarshalMaybeString decodes v into out, accepting both a JSON value and
+// a JSON value encoded as a string (the game client sends both forms).
+func unmarshalMaybeString(v json.RawMessage, out any) error {
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		if s == "" {
+			return errors.New("empty value")
+		}
+		return json.Unmarshal([]byte(s), out)
+	}
+	return json.Unmarshal(v, out)
+}
+
+func parseInt(v json.RawMessage) (int, bool) {
+	var n int
+	if json.Unmarshal(v, &n) == nil {
+		return n, true
+	}
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
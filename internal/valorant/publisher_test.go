@@ -0,0 +1,174 @@
+package valorant
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akayumeru/valreplayserver/internal/domain"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata/apply_payload")
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	// ApplyPayload debug-logs every payload
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+type goldenStep struct {
+	Payload            string   `json:"payload"`
+	Error              bool     `json:"error,omitempty"`
+	Topics             []string `json:"topics"`
+	NewKillFeedEntries int      `json:"newKillFeedEntries,omitempty"`
+	KillFeedReset      bool     `json:"killFeedReset,omitempty"`
+}
+
+type golden struct {
+	Steps []goldenStep `json:"steps"`
+	State domain.State `json:"state"`
+}
+
+// TestApplyPayloadGolden feeds each testdata/apply_payload/<case>/input.jsonl
+// (one payload per line, applied in order) through ApplyPayload and compares
+// every step's topics and the final state with golden.json. Step i runs at
+// 2025-01-01T00:00:00Z + i seconds. An optional initial.json sets the
+// starting state. Every case must give the same result on every run. Run
+// with -update to regenerate.
+func TestApplyPayloadGolden(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "apply_payload", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatal("no test cases in testdata/apply_payload")
+	}
+
+	for _, dir := range dirs {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			b := marshalGolden(t, runCase(t, dir))
+
+			// payload keys come out of maps; a result that depends on their
+			// order only fails now and then, so rerun the case a few times
+			for range 20 {
+				if again := marshalGolden(t, runCase(t, dir)); !bytes.Equal(again, b) {
+					t.Fatalf("result depends on map iteration order:\n%s", lineDiff(string(b), string(again)))
+				}
+			}
+
+			goldenPath := filepath.Join(dir, "golden.json")
+			if *update {
+				if err := os.WriteFile(goldenPath, b, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(want, b) {
+				t.Errorf("result differs from %s (run with -update to accept):\n%s", goldenPath, lineDiff(string(want), string(b)))
+			}
+		})
+	}
+}
+
+func marshalGolden(t *testing.T, g golden) []byte {
+	t.Helper()
+
+	b, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(b, '\n')
+}
+
+func runCase(t *testing.T, dir string) golden {
+	t.Helper()
+
+	st := domain.State{
+		MatchInfo: domain.MatchInfo{
+			Rounds: make(map[int]*domain.Round),
+			Roster: make(map[string]domain.RosterPlayer),
+		},
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "initial.json")); err == nil {
+		if err := json.Unmarshal(b, &st); err != nil {
+			t.Fatalf("initial.json: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "input.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(orig func() time.Time) { now = orig }(now)
+
+	var out golden
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for i := 0; sc.Scan(); i++ {
+		payload := sc.Text()
+		stepTime := base.Add(time.Duration(i) * time.Second)
+		now = func() time.Time { return stepTime }
+
+		next, touched, err := ApplyPayload(st, []byte(payload))
+
+		step := goldenStep{
+			Payload:            payload,
+			Topics:             touched.List(),
+			NewKillFeedEntries: touched.NewKillFeedEntries,
+			KillFeedReset:      touched.KillFeedReset,
+		}
+		// only whether it failed: decoder messages differ between Go releases
+		step.Error = err != nil
+		out.Steps = append(out.Steps, step)
+		st = next
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	out.State = st
+	return out
+}
+
+// lineDiff lists the first differing lines; enough to spot what moved.
+func lineDiff(want, got string) string {
+	wl := strings.Split(want, "\n")
+	gl := strings.Split(got, "\n")
+
+	var b strings.Builder
+	shown := 0
+	for i := 0; i < max(len(wl), len(gl)) && shown < 20; i++ {
+		var w, g string
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if w != g {
+			fmt.Fprintf(&b, "line %d:\n  want: %s\n  got:  %s\n", i+1, w, g)
+			shown++
+		}
+	}
+	return b.String()
+}
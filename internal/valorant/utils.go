@@ -53,6 +53,55 @@ var PhaseDuration = map[string]time.Duration{
 	"game_end": 7 * time.Second,
 }
 
+const (
+	SideAttack  = "attack"
+	SideDefense = "defense"
+
+	RoundsPerHalf = 12
+	FirstOTRound  = 2*RoundsPerHalf + 1
+	SideSwapRound = RoundsPerHalf + 1
+)
+
+func OppositeSide(side string) string {
+	switch side {
+	case SideAttack:
+		return SideDefense
+	case SideDefense:
+		return SideAttack
+	}
+	return ""
+}
+
+// SideForRound returns the side a team that started on startSide plays in
+// the given round: sides swap at round 13 and every round in overtime.
+func SideForRound(startSide string, round int) string {
+	if startSide == "" || round <= 0 {
+		return startSide
+	}
+	if round < SideSwapRound {
+		return startSide
+	}
+	if round < FirstOTRound {
+		return OppositeSide(startSide)
+	}
+	if (round-FirstOTRound)%2 == 0 {
+		return startSide
+	}
+	return OppositeSide(startSide)
+}
+
+// RoundHalf returns 1 or 2 for regulation rounds and 0 with overtime=true after round 24.
+func RoundHalf(round int) (half int, overtime bool) {
+	switch {
+	case round >= FirstOTRound:
+		return 0, true
+	case round >= SideSwapRound:
+		return 2, false
+	default:
+		return 1, false
+	}
+}
+
 func NormalizeAgent(internal string) string {
 	if v, ok := AgentByInternal[internal]; ok {
 		return v
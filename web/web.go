@@ -0,0 +1,18 @@
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed templates
+var embedded embed.FS
+
+// Templates returns the embedded templates directory as the FS root.
+func Templates() fs.FS {
+	sub, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}